@@ -30,35 +30,71 @@ func AttackCommand() *cobra.Command {
 
 	cmd.Flags().StringVar(&opts.name, "name", "", "Attack name")
 	cmd.Flags().StringVarP(&opts.target, "target", "t", "stdin", "Targets file")
-	cmd.Flags().StringVarP(&opts.format, "format", "f", "http", "Target Formats http/yaml/json")
+	cmd.Flags().StringVarP(&opts.format, "format", "f", "yaml", "Target Formats http/yaml/json")
+	cmd.Flags().BoolVar(&opts.loop, "loop", false, "Read the http or json targets upfront and replay them in a loop instead of streaming them once")
 	cmd.Flags().VarP(&rateFlag{&opts.rate}, "rate", "r", "Rate of the requests to be sent")
+	cmd.Flags().StringVar(&opts.pace, "pace", "constant", "Pacer shaping the rate over time constant/linear/step/stages/sine/poisson")
+	cmd.Flags().Float64Var(&opts.slope, "slope", 1, "Rate increase in hits/s every second for --pace=linear")
+	cmd.Flags().Float64Var(&opts.step, "step", 10, "Rate increase in hits/s every --stepEvery for --pace=step")
+	cmd.Flags().DurationVar(&opts.stepEvery, "stepEvery", 10*time.Second, "Interval between rate increases for --pace=step")
+	cmd.Flags().Var(&rateFlag{&opts.amplitude}, "amplitude", "Deviation from --rate at the peaks of --pace=sine")
+	cmd.Flags().DurationVar(&opts.period, "period", time.Minute, "Period of the wave for --pace=sine")
+	cmd.Flags().Float64Var(&opts.phase, "phase", 0, "Offset of the wave at the start of the attack in radians for --pace=sine")
+	cmd.Flags().Int64Var(&opts.seed, "seed", 0, "Seed of the random arrivals for --pace=poisson [0 = random]")
+	cmd.Flags().Var(&stagesFlag{&opts.stages}, "stages", "Stages for --pace=stages as duration:target rate, i.e. 30s:100,1m:500,30s:0")
+	cmd.Flags().StringVar(&opts.executor, "executor", "open", "Executor model: open (hits paced by --rate) or closed (workers loop as fast as responses allow)")
+	cmd.Flags().Uint64Var(&opts.iterations, "iterations", 0, "Iterations per worker for --executor=closed [0 = unlimited]")
+	cmd.Flags().Uint64Var(&opts.sharedIterations, "sharedIterations", 0, "Iterations shared by all workers for --executor=closed [0 = unlimited]")
+	cmd.Flags().DurationVar(&opts.think, "think", 0, "Pause of each worker between its iterations for --executor=closed")
 	cmd.Flags().Uint64Var(&opts.workers, "workers", gogeta.DefaultWorkers, "Number of Virtual Users to be used")
 	cmd.Flags().Uint64Var(&opts.maxWorkers, "maxWorkers", gogeta.DefaultMaxWorkers, "Max Number of Virtual Users to be used")
-	cmd.Flags().Var(&rateFlag{&opts.workerRamp}, "workersRamp", "Ramp rate of workers")
+	cmd.Flags().Var(&rateFlag{&opts.workerRamp}, "workersRamp", "Rate at which workers are added from --workers up to --maxWorkers")
 	cmd.Flags().IntVar(&opts.connections, "connections", gogeta.DefaultConnections, "Max open idle connections per target host")
 	cmd.Flags().IntVar(&opts.maxConnections, "maxConnections", gogeta.DefaultMaxConnections, "Max connections per target host")
 	cmd.Flags().Var(&opts.laddr, "laddr", "Local IP address")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", gogeta.DefaultTimeout, "Requests timeout [0 = none]")
 	cmd.Flags().BoolVar(&opts.keepalive, "keepalive", true, "Use persistent connections")
 	cmd.Flags().StringVarP(&opts.output, "output", "o", "stdout", "Output File")
 	cmd.Flags().DurationVar(&opts.duration, "duration", 0, "Duration of the test [0 = forever]")
+	cmd.Flags().Var(&thresholdsFlag{&opts.thresholds}, "threshold", "Objective the attack must meet, i.e. p99<300ms, success>=99.5%, rate>=0.95*target or errors[timeout]<10 (repeatable)")
+	cmd.Flags().BoolVar(&opts.abortOnBreach, "abortOnBreach", false, "Stop the attack once a threshold can no longer be met")
 
 	return cmd
 }
 
 type attackOpts struct {
-	name           string
-	target         string
-	format         string
-	rate           gogeta.Rate
-	workers        uint64
-	maxWorkers     uint64
-	workerRamp     gogeta.Rate
-	connections    int
-	maxConnections int
-	laddr          localAddr
-	keepalive      bool
-	output         string
-	duration       time.Duration
+	name   string
+	target string
+	format string
+	loop   bool
+	rate   gogeta.Rate
+	// rateSet is set when the rate is given, by --rate or by a plan.
+	rateSet          bool
+	pace             string
+	slope            float64
+	step             float64
+	stepEvery        time.Duration
+	stages           []gogeta.Stage
+	amplitude        gogeta.Rate
+	period           time.Duration
+	phase            float64
+	seed             int64
+	executor         string
+	iterations       uint64
+	sharedIterations uint64
+	think            time.Duration
+	workers          uint64
+	maxWorkers       uint64
+	workerRamp       gogeta.Rate
+	connections      int
+	maxConnections   int
+	laddr            localAddr
+	timeout          time.Duration
+	keepalive        bool
+	output           string
+	duration         time.Duration
+	thresholds       []gogeta.Threshold
+	abortOnBreach    bool
 }
 
 func handleErrors(err error, msg string) {
@@ -69,15 +105,53 @@ func handleErrors(err error, msg string) {
 }
 
 func attack(cmd *cobra.Command, args []string) error {
-	var reader = cmd.InOrStdin()
+	opts.rateSet = cmd.Flags().Changed("rate")
+
+	var (
+		reader = cmd.InOrStdin()
+		err    error
+	)
 	if opts.target != "stdin" {
 		file, err := os.Open(opts.target)
 		handleErrors(err, fmt.Sprintf("Error Opening target file: %v", err))
 		reader = file
 	}
-	targets := gogeta.ProcessReader(reader)
 
-	tr := gogeta.NewStaticTargeter(targets...)
+	var (
+		tr gogeta.Targeter
+		// scenarios are the plans run concurrently with their own settings.
+		scenarios []gogeta.Scenario
+	)
+	switch opts.format {
+	case "http":
+		tr = gogeta.NewHTTPTargeter(reader)
+		if opts.loop {
+			if tr, err = loop(tr); err != nil {
+				return err
+			}
+		}
+	case "json":
+		tr = gogeta.NewJSONTargeter(reader)
+		if opts.loop {
+			if tr, err = loop(tr); err != nil {
+				return err
+			}
+		}
+	case "yaml":
+		config, err := gogeta.ReadConfig(reader)
+		if err != nil {
+			return err
+		}
+		for _, w := range config.Warnings {
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: %s\n", w)
+		}
+		configure(cmd, opts, &config.Configuration)
+		if tr, scenarios, err = plans(cmd, config); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("format %q isn't supported", opts.format)
+	}
 
 	net.DefaultResolver.PreferGo = true
 
@@ -91,18 +165,258 @@ func attack(cmd *cobra.Command, args []string) error {
 		gogeta.LocalAddr(*opts.laddr.IPAddr),
 		gogeta.Workers(opts.workers),
 		gogeta.MaxWorkers(opts.maxWorkers),
+		gogeta.WorkersRamp(opts.workerRamp),
+		gogeta.Timeout(opts.timeout),
 		gogeta.KeepAlive(opts.keepalive),
 		gogeta.Connections(opts.connections),
 		gogeta.MaxConnections(opts.maxConnections),
 	)
 
-	res := atk.Attack(tr, opts.rate, opts.duration, opts.name)
+	var res <-chan *gogeta.Result
+	switch opts.executor {
+	case "open":
+		p, err := pacer(opts)
+		if err != nil {
+			return err
+		}
+		// The shared targets make a scenario too, so that the target rate of
+		// the thresholds accounts for every pacer.
+		if tr != nil {
+			scenarios = append([]gogeta.Scenario{{Targeter: tr, Pacer: p, Duration: opts.duration}}, scenarios...)
+		}
+		if tr != nil && len(scenarios) == 1 {
+			res = atk.Attack(tr, p, opts.duration, opts.name)
+			break
+		}
+		res = atk.AttackScenarios(scenarios, opts.name)
+	case "closed":
+		if len(scenarios) > 0 {
+			return fmt.Errorf("plans with their own rate, stages, duration, startDelay or workers require --executor=open")
+		}
+		// The ramp would keep adding workers, each with iterations of its
+		// own, so nothing would end the attack.
+		ramping := opts.workerRamp.Freq > 0 && opts.workerRamp.Per > 0
+		if ramping && opts.iterations > 0 && opts.sharedIterations == 0 && opts.duration == 0 && opts.maxWorkers == gogeta.DefaultMaxWorkers {
+			return fmt.Errorf("--iterations with --workersRamp never ends without --duration, --maxWorkers or --sharedIterations")
+		}
+		it := gogeta.Iterations{
+			PerVU:  opts.iterations,
+			Shared: opts.sharedIterations,
+			Think:  opts.think,
+		}
+		res = atk.AttackClosed(tr, it, opts.duration, opts.name)
+	default:
+		return fmt.Errorf("executor %q isn't supported", opts.executor)
+	}
+
 	enc := gogeta.NewEncoder(out)
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 
-	return processAttack(atk, res, enc, sig)
+	if len(opts.thresholds) == 0 {
+		return processAttack(atk, res, enc, sig)
+	}
+
+	// Thresholds are evaluated on the Metrics of the Results as they're
+	// encoded.
+	var m gogeta.Metrics
+	encode := enc
+	enc = func(r *gogeta.Result) error {
+		m.Add(r)
+		if opts.abortOnBreach {
+			for _, t := range opts.thresholds {
+				if t.Breached(&m) && atk.Stop() {
+					fmt.Fprintf(cmd.ErrOrStderr(), "threshold %s breached, stopping the attack\n", t)
+				}
+			}
+		}
+		return encode(r)
+	}
+
+	if err = processAttack(atk, res, enc, sig); err != nil {
+		return err
+	}
+
+	m.Close()
+	results, err := gogeta.EvaluateThresholds(opts.thresholds, &m, targetRate(scenarios, m.Duration))
+	if werr := gogeta.WriteThresholds(cmd.ErrOrStderr(), results); werr != nil {
+		return werr
+	}
+	if err != nil {
+		// A breach isn't a problem with the command line.
+		cmd.SilenceUsage = true
+	}
+	return err
+}
+
+// loop reads every Target upfront so that they're replayed in a loop rather
+// than ending the attack once they're exhausted. Targets are otherwise
+// streamed, so that large sources are never buffered in full.
+func loop(tr gogeta.Targeter) (gogeta.Targeter, error) {
+	tgts, err := gogeta.ReadAllTargets(tr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tgts) == 0 {
+		return nil, fmt.Errorf("%s: %w", opts.target, gogeta.ErrNoTargets)
+	}
+	return gogeta.NewStaticTargeter(tgts...), nil
+}
+
+// targetRate returns the mean rate the given open Scenarios aimed for over
+// the given duration of their attack, zero if unknown.
+func targetRate(scenarios []gogeta.Scenario, d time.Duration) float64 {
+	if len(scenarios) == 0 || d <= 0 {
+		return 0
+	}
+
+	// The rates of the pacers are sampled at the middle of as many slices of
+	// the attack.
+	const samples = 1000
+	var sum float64
+	for i := 0; i < samples; i++ {
+		at := time.Duration((float64(i) + 0.5) * float64(d) / samples)
+		for _, sc := range scenarios {
+			elapsed := at - sc.StartDelay
+			if elapsed < 0 || (sc.Duration > 0 && elapsed >= sc.Duration) {
+				continue
+			}
+			sum += sc.Pacer.Rate(elapsed)
+		}
+	}
+	return sum / samples
+}
+
+// configure applies the options of a plan's config block, except for those
+// overridden by flags set on the command line.
+func configure(cmd *cobra.Command, opts *attackOpts, cfg *gogeta.TestConfig) {
+	unset := func(flag string) bool { return !cmd.Flags().Changed(flag) }
 
+	if cfg.Name != "" && unset("name") {
+		opts.name = cfg.Name
+	}
+	if cfg.Output != "" && unset("output") {
+		opts.output = cfg.Output
+	}
+	if cfg.Rate != nil && unset("rate") {
+		opts.rate = *cfg.Rate
+		// The plan's rate counts as given, e.g. as the start of the stages.
+		opts.rateSet = true
+	}
+	if cfg.Duration > 0 && unset("duration") {
+		opts.duration = cfg.Duration
+	}
+	if cfg.Workers > 0 && unset("workers") {
+		opts.workers = cfg.Workers
+	}
+	if cfg.MaxWorkers > 0 && unset("maxWorkers") {
+		opts.maxWorkers = cfg.MaxWorkers
+	}
+	if cfg.Timeout > 0 && unset("timeout") {
+		opts.timeout = cfg.Timeout
+	}
+	if cfg.KeepAlive != nil && unset("keepalive") {
+		opts.keepalive = *cfg.KeepAlive
+	}
+	if cfg.Connections > 0 && unset("connections") {
+		opts.connections = cfg.Connections
+	}
+	if len(cfg.Thresholds) > 0 && unset("threshold") {
+		opts.thresholds = cfg.Thresholds
+	}
+	if cfg.AbortOnBreach && unset("abortOnBreach") {
+		opts.abortOnBreach = true
+	}
+}
+
+// plans returns a weighted Targeter over the plans of the config which share
+// the attack's flags, nil if there are none, and a Scenario for every plan
+// with settings of its own. Scenarios fall back to the flags for the settings
+// they leave out.
+func plans(cmd *cobra.Command, config *gogeta.Config) (gogeta.Targeter, []gogeta.Scenario, error) {
+	var (
+		shared    []gogeta.Target
+		scenarios []gogeta.Scenario
+	)
+
+	for i := range config.TargetPlan {
+		plan := &config.TargetPlan[i]
+		if len(plan.Targets) == 0 {
+			continue
+		}
+
+		tgt, err := plan.Target()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !plan.IsScenario() {
+			shared = append(shared, tgt)
+			continue
+		}
+
+		sc := gogeta.Scenario{
+			Targeter:   gogeta.NewStaticTargeter(tgt),
+			Pacer:      plan.Pacer(),
+			Duration:   plan.Duration,
+			StartDelay: plan.StartDelay,
+			Workers:    plan.Workers,
+			MaxWorkers: plan.MaxWorkers,
+		}
+		if sc.Pacer == nil {
+			if sc.Pacer, err = pacer(opts); err != nil {
+				return nil, nil, err
+			}
+		}
+		if sc.Duration == 0 && opts.duration > 0 {
+			// The duration of the attack counts from its start, which the
+			// scenario may be delayed from.
+			if sc.Duration = opts.duration - sc.StartDelay; sc.Duration <= 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: plan %q starts after the attack's duration and is skipped\n", plan.Name)
+				continue
+			}
+		}
+		scenarios = append(scenarios, sc)
+	}
+
+	if len(shared) == 0 && len(scenarios) > 0 {
+		return nil, scenarios, nil
+	}
+	return gogeta.NewWeightedTargeter(shared...), scenarios, nil
+}
+
+// pacer returns the Pacer selected by the --pace flag.
+func pacer(opts *attackOpts) (gogeta.Pacer, error) {
+	switch opts.pace {
+	case "constant":
+		return opts.rate, nil
+	case "linear":
+		return gogeta.LinearPacer{StartAt: opts.rate, Slope: opts.slope}, nil
+	case "step":
+		return gogeta.StepPacer{StartAt: opts.rate, Step: opts.step, Every: opts.stepEvery}, nil
+	case "stages":
+		if len(opts.stages) == 0 {
+			return nil, fmt.Errorf("--pace=stages requires --stages")
+		}
+		var start *gogeta.Rate
+		if opts.rateSet {
+			start = &opts.rate
+		}
+		return gogeta.NewStagesPacer(start, opts.stages), nil
+	case "sine":
+		if opts.amplitude.Rate(0) > opts.rate.Rate(0) {
+			return nil, fmt.Errorf("--amplitude %s can't exceed --rate %s", &rateFlag{&opts.amplitude}, &rateFlag{&opts.rate})
+		}
+		return gogeta.SinePacer{Period: opts.period, Mean: opts.rate, Amp: opts.amplitude, StartAt: opts.phase}, nil
+	case "poisson":
+		seed := opts.seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		return gogeta.NewPoissonPacer(opts.rate, seed), nil
+	default:
+		return nil, fmt.Errorf("pacer %q isn't supported", opts.pace)
+	}
 }
 
 func processAttack(
@@ -1 +1,89 @@
 package commands
+
+import (
+	"fmt"
+	gogeta "github.com/cool-pants/gogeta/utils"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/signal"
+)
+
+func init() {
+	rootCmd.AddCommand(EncodeCommand())
+}
+
+func EncodeCommand() *cobra.Command {
+	var opts encodeOpts
+
+	var cmd = &cobra.Command{
+		Use:     "encode [files...]",
+		Short:   "convert results between gob, csv and json encodings",
+		Example: "gogeta encode --to csv -o results.csv results.bin",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				args = []string{"stdin"}
+			}
+			return encode(args, &opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.to, "to", "json", "Output encoding [csv, gob, json]")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "stdout", "Output file")
+
+	return cmd
+}
+
+type encodeOpts struct {
+	to     string
+	output string
+}
+
+func encode(files []string, opts *encodeOpts) error {
+	dec, closeAll, err := decoder(files)
+	if err != nil {
+		return err
+	}
+	defer closeAll()
+
+	out, err := file(opts.output, true)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", opts.output, err)
+	}
+	defer out.Close()
+
+	var enc gogeta.Encoder
+	switch opts.to {
+	case "csv":
+		enc = gogeta.NewCSVEncoder(out)
+	case "gob":
+		enc = gogeta.NewEncoder(out)
+	case "json":
+		enc = gogeta.NewJSONEncoder(out)
+	default:
+		return fmt.Errorf("encode: unknown encoding %q", opts.to)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+
+	for {
+		select {
+		case <-sig:
+			return nil
+		default:
+		}
+
+		var r gogeta.Result
+		if err = dec.Decode(&r); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if err = enc.Encode(&r); err != nil {
+			return err
+		}
+	}
+}
@@ -4,9 +4,7 @@ import (
 	"fmt"
 	gogeta "github.com/cool-pants/gogeta/utils"
 	"net"
-	"strconv"
 	"strings"
-	"time"
 )
 
 /*
@@ -14,15 +12,32 @@ List of all the Flags to be supported
 name => name of the attack
 target => File path to be loaded
 format => format of the target file
+loop => Read the http or json targets upfront and replay them in a loop, instead of
+	streaming them once
 rate => Rate of the requests to be sent, need to be configured with a Pacer
+pace => Pacer to use: constant, linear (rate + slope), step (rate + step every stepEvery), stages,
+	sine (rate ± amplitude over period, starting at phase) or poisson (averaging rate, seeded by seed)
+stages => Stages of the stages Pacer (duration:target,...)
+executor => open (hits sent at the pace of rate) or closed (workers loop through the targets)
+iterations => Iterations per worker of the closed executor
+sharedIterations => Iterations shared by all workers of the closed executor
+think => Pause between two iterations of a worker of the closed executor
 workers => Number of Virtual Users to be used
 maxWorkers => Maximum number of Virtual Users that can be used
-workerRamp => By how much to increase the number of workers (workers start at 0 by default)
+workerRamp => Rate at which workers are added, starting from workers up to maxWorkers
+	(without it workers are added whenever all of them are busy)
 connections => Max open idle connections per target host
 maxConnections => Max connections per target host
 laddr => Local IP Address (will send http request)
+timeout => Requests timeout
 keepalive => Use persistent connections (True by Default)
 output => Output file path, stdout by default
+threshold => Objective the attack must meet, i.e. p99<300ms, repeated for each objective
+abortOnBreach => Stop the attack once a threshold can no longer be met
+
+The name, output, rate, duration, workers, maxWorkers, timeout, keepalive,
+connections, thresholds and abortOnBreach can be set in the config block of a
+YAML plan too, the flags taking precedence.
 */
 
 type localAddr struct{ *net.IPAddr }
@@ -43,39 +58,62 @@ func (f *rateFlag) Type() string {
 }
 
 func (f *rateFlag) Set(v string) (err error) {
-	if v == "infinity" {
-		return nil
-	}
+	*f.Rate, err = gogeta.ParseRate(v)
+	return err
+}
 
-	ps := strings.SplitN(v, "/", 2)
-	switch len(ps) {
-	case 1:
-		ps = append(ps, "1s")
-	case 0:
-		return fmt.Errorf("-rate format %q doesn't match the \"freq/duration\" format (i.e. 50/1s)", v)
+func (f *rateFlag) String() string {
+	if f.Rate == nil {
+		return ""
 	}
+	return fmt.Sprintf("%d/%s", f.Freq, f.Per)
+}
 
-	f.Freq, err = strconv.Atoi(ps[0])
-	if err != nil {
-		return err
-	}
+type stagesFlag struct{ stages *[]gogeta.Stage }
 
-	if f.Freq == 0 {
-		return nil
-	}
+func (f *stagesFlag) Type() string {
+	return "Stages"
+}
 
-	switch ps[1] {
-	case "ns", "us", "µs", "ms", "s", "m", "h":
-		ps[1] = "1" + ps[1]
+func (f *stagesFlag) Set(v string) (err error) {
+	*f.stages, err = gogeta.ParseStages(v)
+	return err
+}
+
+func (f *stagesFlag) String() string {
+	if f.stages == nil {
+		return ""
 	}
+	ps := make([]string, 0, len(*f.stages))
+	for _, st := range *f.stages {
+		ps = append(ps, fmt.Sprintf("%s:%g", st.Duration, st.Target))
+	}
+	return strings.Join(ps, ",")
+}
 
-	f.Per, err = time.ParseDuration(ps[1])
-	return err
+type thresholdsFlag struct{ thresholds *[]gogeta.Threshold }
+
+func (f *thresholdsFlag) Type() string {
+	return "Threshold"
 }
 
-func (f *rateFlag) String() string {
-	if f.Rate == nil {
+// Set adds a Threshold, so that the flag can be repeated.
+func (f *thresholdsFlag) Set(v string) error {
+	t, err := gogeta.ParseThreshold(v)
+	if err != nil {
+		return err
+	}
+	*f.thresholds = append(*f.thresholds, t)
+	return nil
+}
+
+func (f *thresholdsFlag) String() string {
+	if f.thresholds == nil {
 		return ""
 	}
-	return fmt.Sprintf("%d/%s", f.Freq, f.Per)
+	ts := make([]string, 0, len(*f.thresholds))
+	for _, t := range *f.thresholds {
+		ts = append(ts, t.String())
+	}
+	return strings.Join(ts, ",")
 }
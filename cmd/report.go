@@ -1 +1,226 @@
 package commands
+
+import (
+	"errors"
+	"fmt"
+	gogeta "github.com/cool-pants/gogeta/utils"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func init() {
+	rootCmd.AddCommand(ReportCommand())
+}
+
+func ReportCommand() *cobra.Command {
+	var opts reportOpts
+
+	var cmd = &cobra.Command{
+		Use:     "report [files...]",
+		Short:   "report the results of an attack",
+		Example: "gogeta attack -o results.bin < plan.yaml && gogeta report --type=hist[0,10ms,50ms,100ms] results.bin",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				args = []string{"stdin"}
+			}
+			err := report(cmd, args, &opts)
+			if errors.Is(err, gogeta.ErrThresholdsBreached) {
+				// A breach isn't a problem with the command line.
+				cmd.SilenceUsage = true
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.typ, "type", "text", "Report type to generate [text, json, hist[buckets]]")
+	cmd.Flags().DurationVar(&opts.every, "every", 0, "Report interval")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "stdout", "Output file")
+	cmd.Flags().BoolVar(&opts.histograms, "histograms", false, "Include the latency histograms in json reports, so that they can be merged")
+	cmd.Flags().IntVar(&opts.precision, "precision", gogeta.DefaultPrecision, "Significant digits of the reported latencies, from 1 to 5")
+	cmd.Flags().Var(&thresholdsFlag{&opts.thresholds}, "threshold", "Objective the attack must have met, i.e. p99<300ms (repeatable)")
+	cmd.Flags().Var(&rateFlag{&opts.targetRate}, "targetRate", "Rate the attack aimed for, compared with by thresholds such as rate>=0.95*target")
+
+	return cmd
+}
+
+type reportOpts struct {
+	typ        string
+	every      time.Duration
+	output     string
+	histograms bool
+	precision  int
+	thresholds []gogeta.Threshold
+	targetRate gogeta.Rate
+}
+
+func report(cmd *cobra.Command, files []string, opts *reportOpts) error {
+	if len(opts.typ) < 4 {
+		return fmt.Errorf("invalid report type: %s", opts.typ)
+	}
+
+	dec, closeAll, err := decoder(files)
+	if err != nil {
+		return err
+	}
+	defer closeAll()
+
+	if opts.precision < 1 || opts.precision > 5 {
+		return fmt.Errorf("--precision %d isn't between 1 and 5", opts.precision)
+	}
+
+	// Every report is drawn from the same Metrics, which the thresholds are
+	// evaluated on too.
+	var (
+		rep     gogeta.Reporter
+		metrics gogeta.Metrics
+	)
+	metrics.Latencies.Precision = opts.precision
+	metrics.ResponseTimes.Precision = opts.precision
+
+	switch opts.typ[:4] {
+	case "text":
+		rep = gogeta.NewTextReporter(&metrics)
+	case "json":
+		if opts.histograms {
+			rep = gogeta.NewMergeableJSONReporter(&metrics)
+		} else {
+			rep = gogeta.NewJSONReporter(&metrics)
+		}
+	case "hist":
+		if len(opts.typ) < 6 {
+			return fmt.Errorf("bad buckets: '%s'", opts.typ[4:])
+		}
+		var hist gogeta.Histogram
+		if err := hist.Buckets.UnmarshalText([]byte(opts.typ[4:])); err != nil {
+			return err
+		}
+		metrics.Histogram = &hist
+		rep = gogeta.NewHistogramReporter(&hist)
+	default:
+		return fmt.Errorf("invalid report type: %s", opts.typ)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+
+	out, err := file(opts.output, true)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %s", opts.output, err)
+	}
+	defer out.Close()
+
+	var ticks <-chan time.Time
+	if opts.every > 0 {
+		ticker := time.NewTicker(opts.every)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	// Decoding blocks while a live attack is piping in, so it happens on its
+	// own goroutine and the loop below stays responsive to ticks and signals.
+	results := make(chan *gogeta.Result)
+	decodeErr := make(chan error, 1)
+	go func() {
+		defer close(results)
+		for {
+			var r gogeta.Result
+			if err := dec.Decode(&r); err != nil {
+				if err != io.EOF {
+					decodeErr <- err
+				}
+				return
+			}
+			results <- &r
+		}
+	}()
+
+	// done writes the final report, followed by the outcome of the
+	// thresholds.
+	done := func() error {
+		if err := writeReport(rep, &metrics, out); err != nil || len(opts.thresholds) == 0 {
+			return err
+		}
+		results, err := gogeta.EvaluateThresholds(opts.thresholds, &metrics, opts.targetRate.Rate(0))
+		if werr := gogeta.WriteThresholds(cmd.ErrOrStderr(), results); werr != nil {
+			return werr
+		}
+		return err
+	}
+
+	for {
+		select {
+		case <-sig:
+			return done()
+		case <-ticks:
+			if err := clearScreen(out); err != nil {
+				return err
+			}
+			if err := writeReport(rep, &metrics, out); err != nil {
+				return err
+			}
+		case r, ok := <-results:
+			if !ok {
+				select {
+				case err := <-decodeErr:
+					return err
+				default:
+				}
+				return done()
+			}
+			metrics.Add(r)
+		}
+	}
+}
+
+// decoder opens all the given files and returns a Decoder that round robins
+// across them, along with a function closing every opened file.
+func decoder(files []string) (gogeta.Decoder, func(), error) {
+	var (
+		opened []io.Closer
+		decs   = make([]gogeta.Decoder, 0, len(files))
+	)
+
+	closeAll := func() {
+		for _, c := range opened {
+			c.Close()
+		}
+	}
+
+	for _, f := range files {
+		rc, err := file(f, false)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		opened = append(opened, rc)
+
+		dec := gogeta.DecoderFor(rc)
+		if dec == nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("can't detect encoding of %q", f)
+		}
+		decs = append(decs, dec)
+	}
+
+	return gogeta.NewRoundRobinDecoder(decs...), closeAll, nil
+}
+
+func writeReport(r gogeta.Reporter, rc gogeta.Closer, out io.Writer) error {
+	if rc != nil {
+		rc.Close()
+	}
+	return r.Report(out)
+}
+
+// clearScreen wipes the terminal before a periodic report is re-printed.
+// Nothing is written when the output isn't a terminal.
+func clearScreen(out *os.File) error {
+	if fi, err := out.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	_, err := io.WriteString(out, "\033[2J\033[H")
+	return err
+}
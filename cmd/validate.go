@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"fmt"
+	gogeta "github.com/cool-pants/gogeta/utils"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+func init() {
+	rootCmd.AddCommand(ValidateCommand())
+}
+
+func ValidateCommand() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:     "validate [file]",
+		Short:   "check a plan for mistakes without running it",
+		Example: "gogeta validate plan.yaml",
+		Args:    cobra.MaximumNArgs(1),
+		// Problems with the plan aren't problems with the command line.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := "stdin"
+			if len(args) > 0 {
+				name = args[0]
+			}
+			return validate(cmd.OutOrStdout(), name)
+		},
+	}
+
+	return cmd
+}
+
+func validate(out io.Writer, name string) error {
+	in, err := file(name, false)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	problems, err := gogeta.ValidatePlan(in)
+	if err != nil {
+		return err
+	}
+
+	errs := 0
+	for _, p := range problems {
+		if !p.Warning {
+			errs++
+		}
+		if _, err = fmt.Fprintf(out, "%s:%s\n", name, p); err != nil {
+			return err
+		}
+	}
+
+	if errs > 0 {
+		return fmt.Errorf("%s: %d error(s) found", name, errs)
+	}
+	return nil
+}
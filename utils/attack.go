@@ -1,14 +1,14 @@
 package gogeta
 
 import (
-	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"math"
 	"net"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +21,10 @@ var (
 	DefaultTimeout               = 30 * time.Second
 )
 
+// ErrInterrupted is recorded in the Result of an iteration which was cut
+// short by the attack being stopped while it was pausing.
+var ErrInterrupted = errors.New("iteration interrupted")
+
 type Attacker struct {
 	dialer     *net.Dialer
 	client     http.Client
@@ -28,6 +32,7 @@ type Attacker struct {
 	stopOnce   sync.Once
 	workers    uint64
 	maxWorkers uint64
+	workerRamp Rate
 }
 
 func LocalAddr(addr net.IPAddr) func(*Attacker) {
@@ -46,7 +51,24 @@ func Workers(w uint64) func(*Attacker) {
 
 func MaxWorkers(w uint64) func(*Attacker) {
 	return func(a *Attacker) {
-		a.workers = w
+		a.maxWorkers = w
+	}
+}
+
+// WorkersRamp makes the Attacker add workers at the given rate, starting
+// from the number of Workers until MaxWorkers is reached, instead of adding
+// them whenever all workers are busy.
+func WorkersRamp(r Rate) func(*Attacker) {
+	return func(a *Attacker) {
+		a.workerRamp = r
+	}
+}
+
+// Timeout sets the timeout of each request, including reading its
+// response. Zero means no timeout.
+func Timeout(d time.Duration) func(*Attacker) {
+	return func(a *Attacker) {
+		a.client.Timeout = d
 	}
 }
 
@@ -106,12 +128,29 @@ func NewAttacker(opts ...func(a *Attacker)) *Attacker {
 }
 
 func (a *Attacker) Attack(tr Targeter, p Pacer, du time.Duration, name string) <-chan *Result {
-	var wg sync.WaitGroup
+	return a.AttackScenarios([]Scenario{{Targeter: tr, Pacer: p, Duration: du}}, name)
+}
 
-	workers := a.workers
-	if workers > a.maxWorkers {
-		workers = a.maxWorkers
-	}
+// Scenario is an open model attack run by AttackScenarios alongside other
+// Scenarios, each with its own Targeter, Pacer and pool of workers. Zero
+// Workers and MaxWorkers fall back to the Attacker's own.
+type Scenario struct {
+	Targeter Targeter
+	Pacer    Pacer
+	// Duration bounds the Scenario from its start, zero meaning it runs
+	// until its Pacer stops or the attack is stopped.
+	Duration time.Duration
+	// StartDelay postpones the start of the Scenario.
+	StartDelay time.Duration
+	Workers    uint64
+	MaxWorkers uint64
+}
+
+// AttackScenarios runs the given Scenarios concurrently, merging their
+// Results into a single stream with one sequence of numbers. The stream is
+// closed once every Scenario is done, or Stop is called.
+func (a *Attacker) AttackScenarios(scenarios []Scenario, name string) <-chan *Result {
+	var wg sync.WaitGroup
 
 	atk := &attack{
 		name:  name,
@@ -119,61 +158,285 @@ func (a *Attacker) Attack(tr Targeter, p Pacer, du time.Duration, name string) <
 	}
 
 	results := make(chan *Result)
-	ticks := make(chan struct{})
-	for i := uint64(0); i < workers; i++ {
+	for _, sc := range scenarios {
 		wg.Add(1)
-		go a.attack(tr, atk, &wg, ticks, results)
+		go func(sc Scenario) {
+			defer wg.Done()
+			if a.sleep(sc.StartDelay) {
+				a.open(atk, sc, results)
+			}
+		}(sc)
 	}
 
 	go func() {
-		defer func() {
-			close(ticks)
-			wg.Wait()
-			close(results)
-			a.Stop()
-		}()
-
-		count := uint64(0)
-		for {
-			elapsed := time.Since(atk.began)
-			if du > 0 && elapsed > du {
-				return
-			}
+		wg.Wait()
+		close(results)
+		a.Stop()
+	}()
 
-			wait, stop := p.Pace(elapsed, count)
-			if stop {
-				return
-			}
+	return results
+}
 
-			time.Sleep(wait)
-
-			if workers < a.maxWorkers {
-				select {
-				case ticks <- struct{}{}:
-					count++
-					continue
-				case <-a.stopch:
-					return
-				default:
-					// all workers are blocked. start one more and try again
-					workers++
-					wg.Add(1)
-					go a.attack(tr, atk, &wg, ticks, results)
-				}
-			}
+// open runs a single Scenario of an attack, returning once all of its
+// workers are done.
+func (a *Attacker) open(atk *attack, sc Scenario, results chan<- *Result) {
+	var wg sync.WaitGroup
+
+	workers, maxWorkers := sc.Workers, sc.MaxWorkers
+	if workers == 0 {
+		workers = a.workers
+	}
+	if maxWorkers == 0 {
+		maxWorkers = a.maxWorkers
+	}
+	if workers > maxWorkers {
+		workers = maxWorkers
+	}
+
+	var (
+		began   = time.Now()
+		ticks   = make(chan time.Time)
+		started uint64 // workers of this scenario, accessed atomically
+		done    = make(chan struct{})
+		doneOne sync.Once
+	)
+
+	// stop ends the Scenario alone, leaving the others of the attack be.
+	stop := func() { doneOne.Do(func() { close(done) }) }
+
+	spawn := func() {
+		wg.Add(1)
+		atomic.AddUint64(&atk.workers, 1)
+		w := &worker{vu: atomic.AddUint64(&started, 1), templates: &templates{}, stop: stop}
+		go a.attack(sc.Targeter, atk, w, &wg, ticks, results)
+	}
+
+	for i := uint64(0); i < workers; i++ {
+		spawn()
+	}
+
+	// With a ramp, workers are added on its schedule. Otherwise they're
+	// added on demand whenever all of them are busy.
+	ramping := a.workerRamp.Freq > 0 && a.workerRamp.Per > 0
 
+	rampStop := make(chan struct{})
+	rampDone := make(chan struct{})
+	go func() {
+		defer close(rampDone)
+		if ramping {
+			a.ramp(began, &started, maxWorkers, spawn, rampStop)
+		}
+	}()
+
+	defer func() {
+		// The ramp must be done spawning workers before waiting on them.
+		close(rampStop)
+		<-rampDone
+		close(ticks)
+		wg.Wait()
+	}()
+
+	count := uint64(0)
+	for {
+		elapsed := time.Since(began)
+		if sc.Duration > 0 && elapsed > sc.Duration {
+			return
+		}
+
+		wait, stop := sc.Pacer.Pace(elapsed, count)
+		if stop {
+			return
+		}
+
+		// The hit is meant to be sent at this time, which it's only late
+		// for when all the workers are busy. Its Result keeps it so that
+		// the delay counts towards its response time.
+		intended := began.Add(elapsed + wait)
+
+		time.Sleep(wait)
+
+		if !ramping && atomic.LoadUint64(&started) < maxWorkers {
 			select {
-			case ticks <- struct{}{}:
+			case ticks <- intended:
 				count++
+				continue
 			case <-a.stopch:
 				return
+			case <-done:
+				return
+			default:
+				// all workers are blocked. start one more and try again
+				spawn()
 			}
 		}
+
+		select {
+		case ticks <- intended:
+			count++
+		case <-a.stopch:
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// ramp adds workers at the pace of the Attacker's worker ramp, measured
+// from began, until there are max of them or the given channel is closed.
+func (a *Attacker) ramp(began time.Time, workers *uint64, max uint64, spawn func(), stop <-chan struct{}) {
+	for count := uint64(0); atomic.LoadUint64(workers) < max; count++ {
+		wait, done := a.workerRamp.Pace(time.Since(began), count)
+		if done {
+			return
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			spawn()
+		case <-stop:
+			timer.Stop()
+			return
+		case <-a.stopch:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Iterations configures the closed model executor of AttackClosed.
+type Iterations struct {
+	// PerVU is the number of iterations each worker runs, zero meaning
+	// no limit.
+	PerVU uint64
+	// Shared is the number of iterations run by all workers together, zero
+	// meaning no limit.
+	Shared uint64
+	// Think is the pause each worker takes between two of its iterations.
+	Think time.Duration
+}
+
+// AttackClosed runs a closed model attack: instead of sending hits at the
+// pace of a Pacer, every worker hits the next Target as soon as it's done
+// with the previous one, optionally thinking in between. The throughput is
+// thus only bounded by the number of workers and the targets' latency. The
+// attack stops once the iterations are exhausted, du elapses or Stop is
+// called. Workers are added by the worker ramp if there is one, in which
+// case PerVU iterations only end the attack once MaxWorkers are done.
+func (a *Attacker) AttackClosed(tr Targeter, it Iterations, du time.Duration, name string) <-chan *Result {
+	var wg sync.WaitGroup
+
+	workers := a.workers
+	if workers > a.maxWorkers {
+		workers = a.maxWorkers
+	}
+
+	atk := &attack{
+		name:  name,
+		began: time.Now(),
+	}
+
+	var (
+		results  = make(chan *Result)
+		rampStop = make(chan struct{})
+		rampOnce sync.Once
+		started  uint64 // shared iterations started so far, accessed atomically
+	)
+
+	finish := func() { rampOnce.Do(func() { close(rampStop) }) }
+
+	var deadline *time.Timer
+	if du > 0 {
+		deadline = time.AfterFunc(du, finish)
+	}
+
+	// next reports whether a worker may start another iteration.
+	next := func() bool {
+		if du > 0 && time.Since(atk.began) >= du {
+			return false
+		}
+
+		select {
+		case <-a.stopch:
+			return false
+		default:
+		}
+
+		if it.Shared > 0 && atomic.AddUint64(&started, 1) > it.Shared {
+			finish()
+			return false
+		}
+
+		return true
+	}
+
+	// The closed attack is a single scenario, so running out of targets or
+	// data ends the attack.
+	stop := func() { a.Stop() }
+
+	spawn := func() {
+		wg.Add(1)
+		w := &worker{vu: atomic.AddUint64(&atk.workers, 1), templates: &templates{}, stop: stop}
+		go a.iterate(tr, atk, w, it, next, &wg, results)
+	}
+
+	for i := uint64(0); i < workers; i++ {
+		spawn()
+	}
+
+	go func() {
+		if a.workerRamp.Freq > 0 && a.workerRamp.Per > 0 {
+			// The ramp must be done spawning workers before waiting on them.
+			a.ramp(atk.began, &atk.workers, a.maxWorkers, spawn, rampStop)
+		}
+
+		wg.Wait()
+		if deadline != nil {
+			deadline.Stop()
+		}
+		close(results)
+		a.Stop()
 	}()
 
 	return results
 }
 
+// iterate is the loop of a single closed model worker.
+func (a *Attacker) iterate(tr Targeter, atk *attack, w *worker, it Iterations, next func() bool, workers *sync.WaitGroup, results chan<- *Result) {
+	defer workers.Done()
+	for i := uint64(0); it.PerVU == 0 || i < it.PerVU; i++ {
+		if i > 0 && !a.sleep(it.Think) {
+			return
+		}
+
+		if !next() {
+			return
+		}
+
+		for _, r := range a.hit(tr, atk, w, time.Time{}) {
+			results <- r
+		}
+	}
+}
+
+// sleep pauses for the given duration, returning false if the Attacker was
+// stopped in the meantime.
+func (a *Attacker) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-a.stopch:
+		return false
+	}
+}
+
 func (a *Attacker) Stop() bool {
 	select {
 	case <-a.stopch:
@@ -190,20 +453,45 @@ type attack struct {
 
 	seqmu sync.Mutex
 	seq   uint64
+
+	// workers is the number of workers started so far by all scenarios,
+	// accessed atomically.
+	workers uint64
 }
 
-func (a *Attacker) attack(tr Targeter, atk *attack, workers *sync.WaitGroup, ticks <-chan struct{}, results chan<- *Result) {
+// worker is a worker of a scenario of an attack.
+type worker struct {
+	// vu is the number of the worker within its scenario, from 1.
+	vu uint64
+	// templates are the clones of request templates of the worker.
+	templates *templates
+	// stop ends the scenario of the worker, once its targets or data run
+	// out.
+	stop func()
+}
+
+func (a *Attacker) attack(tr Targeter, atk *attack, w *worker, workers *sync.WaitGroup, ticks <-chan time.Time, results chan<- *Result) {
 	defer workers.Done()
-	for range ticks {
-		results <- a.hit(tr, atk)
+	for intended := range ticks {
+		for _, r := range a.hit(tr, atk, w, intended) {
+			results <- r
+		}
 	}
 }
 
-func (a *Attacker) hit(tr Targeter, atk *attack) *Result {
+// hit runs one iteration of a Target chain. When the chain has more than
+// one step, a Result is returned for every step followed by the Result of
+// the iteration as a whole, all sharing the same sequence number. w is the
+// worker running the iteration, and intended is the time its pacer meant it
+// to start at, zero without a pacer.
+func (a *Attacker) hit(tr Targeter, atk *attack, w *worker, intended time.Time) []*Result {
 	var (
-		res         = Result{Attack: atk.name}
+		res         = Result{Attack: atk.name, Intended: intended}
 		tgt *Target = &Target{}
 		err error
+		// paused is the time spent thinking and pacing, which counts
+		// towards the iteration duration but not its latency.
+		paused time.Duration
 	)
 
 	//
@@ -222,75 +510,177 @@ func (a *Attacker) hit(tr Targeter, atk *attack) *Result {
 	atk.seq++
 	atk.seqmu.Unlock()
 
+	res.Workers = atomic.LoadUint64(&atk.workers)
+
 	defer func() {
-		res.Latency = time.Since(res.Timestamp)
+		res.Iteration = time.Since(res.Timestamp)
+		res.Latency = res.Iteration - paused
 		if err != nil {
 			res.Error = err.Error()
 		}
 	}()
 
-	/*This is where I have to implement the chained Requests Magic*/
+	scope := NewScope()
+	scope.Seq, scope.VU, scope.templates = res.Seq, w.vu, w.templates
+
+	// The Targeter hands out a whole chain at once, so it must only be
+	// consulted once per hit; the remaining steps are reached through Next.
+	if err = tr(tgt); err != nil {
+		w.stop()
+		if errors.Is(err, ErrNoTargets) {
+			// Like running out of data, running out of targets ends the
+			// scenario rather than failing the iteration.
+			return nil
+		}
+		return []*Result{&res}
+	}
 
-	var cache = make(map[string]string)
+	res.Plan = tgt.Plan
 
-	for {
-		if err = tr(tgt); err != nil {
-			a.Stop()
-			return &res
+	if tgt.Data != nil {
+		var row map[string]string
+		if row, err = tgt.Data.Row(w.vu); err != nil {
+			if errors.Is(err, ErrDataExhausted) {
+				// Running out of data ends the scenario rather than failing
+				// the iteration, so there's nothing to report.
+				w.stop()
+				return nil
+			}
+			return []*Result{&res}
+		}
+		for k, v := range row {
+			scope.Vars[k] = v
 		}
+	}
 
-		res.Method = tgt.Method
-		res.URL = tgt.URL
+	pacing := tgt.Pacing
+	chained := tgt.Next != nil
 
-		req, err := tgt.Request(cache)
-		if err != nil {
-			return &res
+	// pause sleeps for the given duration, accounting for it in paused.
+	pause := func(d time.Duration) bool {
+		start := time.Now()
+		defer func() { paused += time.Since(start) }()
+		return a.sleep(d)
+	}
+
+	var results []*Result
+	for i := 1; tgt != nil; i, tgt = i+1, tgt.Next {
+		if !chained {
+			res.StepName = tgt.Name
+			if err = a.step(tgt, atk, scope, &res); err != nil {
+				break
+			}
+		} else {
+			step := &Result{
+				Attack:    atk.name,
+				Seq:       res.Seq,
+				Plan:      res.Plan,
+				Step:      i,
+				StepName:  tgt.Name,
+				Timestamp: atk.began.Add(time.Since(atk.began)),
+				Workers:   atomic.LoadUint64(&atk.workers),
+			}
+			results = append(results, step)
+
+			stepErr := a.step(tgt, atk, scope, step)
+			step.Latency = time.Since(step.Timestamp)
+			if stepErr != nil {
+				step.Error = stepErr.Error()
+			}
+
+			res.Method, res.URL = step.Method, step.URL
+			res.Code = step.Code
+			res.BytesIn += step.BytesIn
+			res.BytesOut += step.BytesOut
+			if res.Error == "" {
+				res.Error = step.Error
+			}
+
+			if stepErr != nil {
+				err = stepErr
+				break
+			}
 		}
 
-		if atk.name != "" {
-			req.Header.Set("X-Gogeta-Attack", atk.name)
+		if tgt.Think != nil && !pause(tgt.Think.Sample()) {
+			err = ErrInterrupted
+			break
 		}
+	}
 
-		req.Header.Set("X-Gogeta-Seq", strconv.FormatUint(res.Seq, 10))
+	if rest := pacing - time.Since(res.Timestamp); err == nil && rest > 0 && !pause(rest) {
+		err = ErrInterrupted
+	}
 
-		r, err := a.client.Do(req)
-		if err != nil {
-			return &res
-		}
-		defer r.Body.Close()
+	return append(results, &res)
+}
 
-		body := io.Reader(r.Body)
+// step sends the request of a single Target of a chain, recording the
+// outcome and its checks in the given Result and the values it extracts in
+// the Scope.
+func (a *Attacker) step(tgt *Target, atk *attack, scope *Scope, res *Result) (err error) {
+	began := time.Now()
+	res.Method = tgt.Method
+	res.URL = tgt.URL
 
-		if res.Body, err = io.ReadAll(body); err != nil {
-			return &res
-		} else if _, err = io.Copy(io.Discard, r.Body); err != nil {
-			return &res
-		}
+	if err = scope.bind(tgt.Bind); err != nil {
+		return err
+	}
 
-		resBody := make(map[string]string)
+	req, err := tgt.Request(scope)
+	if err != nil {
+		return err
+	}
 
-		err = json.Unmarshal(res.Body, &resBody)
-		handleErrors(err, fmt.Sprintf("Failure in Unmarshalling Response %v", err))
+	// The Target's URL may be a template, which is only recorded if the
+	// request couldn't be built from it.
+	res.URL = req.URL.String()
 
-		cache["txn_uuid"] = resBody["id"]
+	if atk.name != "" {
+		req.Header.Set("X-Gogeta-Attack", atk.name)
+	}
 
-		res.BytesIn = uint64(len(res.Body))
+	req.Header.Set("X-Gogeta-Seq", strconv.FormatUint(res.Seq, 10))
 
-		if req.ContentLength != -1 {
-			res.BytesOut = uint64(req.ContentLength)
-		}
+	// Streamed bodies of unknown length are counted as they're sent.
+	var sent *countingReader
+	if req.ContentLength == -1 && req.Body != nil {
+		sent = &countingReader{ReadCloser: req.Body}
+		req.Body = sent
+	}
 
-		if res.Code = uint16(r.StatusCode); res.Code < 200 || res.Code >= 400 {
-			res.Error = r.Status
-		}
+	r, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
 
-		res.Headers = r.Header
+	body := io.Reader(r.Body)
 
-		if tgt.Next == nil {
-			break
-		} else {
-			tgt = tgt.Next
-		}
+	if res.Body, err = io.ReadAll(body); err != nil {
+		return err
+	} else if _, err = io.Copy(io.Discard, r.Body); err != nil {
+		return err
+	}
+
+	res.BytesIn = uint64(len(res.Body))
+
+	if sent != nil {
+		res.BytesOut = uint64(atomic.LoadInt64(&sent.n))
+	} else if req.ContentLength != -1 {
+		res.BytesOut = uint64(req.ContentLength)
 	}
-	return &res
+
+	// A status check takes the place of the range of successful codes.
+	if res.Code = uint16(r.StatusCode); (res.Code < 200 || res.Code >= 400) && !checksStatus(tgt.Checks) {
+		res.Error = r.Status
+	}
+
+	res.Headers = r.Header
+
+	if err = runChecks(tgt.Checks, res, r.Header, time.Since(began)); err != nil {
+		return err
+	}
+
+	return scope.extract(tgt.Extract, r.Header, res.Body)
 }
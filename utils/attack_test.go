@@ -0,0 +1,97 @@
+package gogeta
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttackFiniteJSONTargets(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	src := fmt.Sprintf(`{"method":"GET","url":"%[1]s/a","next":{"method":"GET","url":"%[1]s/b"}}`+"\n", srv.URL)
+	tr := NewJSONTargeter(strings.NewReader(src))
+
+	atk := NewAttacker(Workers(4))
+	var results []*Result
+	for r := range atk.Attack(tr, Rate{Freq: 100, Per: time.Second}, 2*time.Second, "") {
+		results = append(results, r)
+	}
+
+	// Two steps and the iteration as a whole.
+	if got, want := len(results), 3; got != want {
+		t.Fatalf("got %d results, want %d", got, want)
+	}
+	for _, r := range results {
+		if r.Error != "" || r.Code != http.StatusOK {
+			t.Errorf("result of step %d: code %d, error %q", r.Step, r.Code, r.Error)
+		}
+	}
+}
+
+func TestAttackScenariosDataExhaustedStopsItsScenario(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	feeder, err := NewFeeder([]map[string]string{{"id": "1"}, {"id": "2"}}, DataStopWhenExhausted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rate := Rate{Freq: 20, Per: time.Second}
+	scenarios := []Scenario{
+		{Targeter: NewStaticTargeter(Target{Method: "GET", URL: srv.URL + "/data", Plan: "data", Data: feeder}), Pacer: rate},
+		{Targeter: NewStaticTargeter(Target{Method: "GET", URL: srv.URL + "/other", Plan: "other"}), Pacer: rate, Duration: 500 * time.Millisecond},
+	}
+
+	counts := map[string]int{}
+	for r := range NewAttacker(Workers(2)).AttackScenarios(scenarios, "") {
+		counts[r.Plan]++
+	}
+
+	if got, want := counts["data"], 2; got != want {
+		t.Errorf("got %d results of the data plan, want %d", got, want)
+	}
+	// The other scenario runs through its duration despite the data of the
+	// first one running out after two hits.
+	if got := counts["other"]; got < 8 {
+		t.Errorf("got %d results of the other plan, want about 10", got)
+	}
+}
+
+func TestAttackStatusCheckAcceptsErrorCodes(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		checks  []Check
+		wantErr string
+	}{
+		{nil, "404 Not Found"},
+		{[]Check{{Name: "gone", Status: []int{404}}}, ""},
+		{[]Check{{Name: "ok", Status: []int{200}}}, `check "ok" failed`},
+	} {
+		tr := NewStaticTargeter(Target{Method: "GET", URL: srv.URL, Checks: tc.checks})
+		var results []*Result
+		for r := range NewAttacker(Workers(1)).Attack(tr, Rate{Freq: 1, Per: time.Second}, 500*time.Millisecond, "") {
+			results = append(results, r)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("checks %v: got %d results, want 1", tc.checks, len(results))
+		}
+		if got := results[0].Error; got != tc.wantErr {
+			t.Errorf("checks %v: got error %q, want %q", tc.checks, got, tc.wantErr)
+		}
+	}
+}
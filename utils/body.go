@@ -0,0 +1,292 @@
+package gogeta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// RequestBody is the body of a step's request in a plan. It's given in one
+// of the following modes, each setting a default Content-Type:
+//
+//	body: {json: [1, 2, 3]}                      # application/json
+//	body: {raw: "<ping/>", contentType: text/xml} # text/plain by default
+//	body: {form: {user: me, pass: "${pass}"}}     # application/x-www-form-urlencoded
+//	body: {multipart: {fields: {name: me}, files: {avatar: ./me.png}}}
+//	body: {file: ./payload.bin}                   # guessed from the extension
+//
+// Any other mapping or sequence is sent as JSON and a plain string as raw
+// text, so a JSON object whose keys are all mode names has to be wrapped in
+// json. Files are streamed from disk on every request.
+type RequestBody struct {
+	JSON        any               `yaml:"json"`
+	Raw         *string           `yaml:"raw"`
+	Form        map[string]string `yaml:"form"`
+	Multipart   *Multipart        `yaml:"multipart"`
+	File        string            `yaml:"file"`
+	ContentType string            `yaml:"contentType"`
+
+	isJSON bool
+}
+
+// Multipart is a multipart/form-data request body.
+type Multipart struct {
+	// Fields holds the plain form fields. Their values may reference
+	// variables as ${name}.
+	Fields map[string]string `json:"fields,omitempty" yaml:"fields"`
+	// Files maps form fields to the paths of the files uploaded in them.
+	Files map[string]string `json:"files,omitempty" yaml:"files"`
+}
+
+var isBodyMode = map[string]bool{"json": true, "raw": true, "form": true, "multipart": true, "file": true}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (b *RequestBody) UnmarshalYAML(node *yaml.Node) error {
+	switch {
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!null":
+		return nil
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!str":
+		b.Raw = &node.Value
+		return nil
+	case node.Kind == yaml.MappingNode:
+		switch modes := bodyModes(node); {
+		case modes > 1:
+			return fmt.Errorf("body can only have one of json, raw, form, multipart or file")
+		case modes == 1:
+			type plain RequestBody
+			if err := node.Decode((*plain)(b)); err != nil {
+				return err
+			}
+			b.isJSON = field(node, "json") != nil
+			return nil
+		}
+	}
+
+	b.isJSON = true
+	return node.Decode(&b.JSON)
+}
+
+// bodyModes returns the number of body modes a mapping selects, zero if it's
+// a JSON object.
+func bodyModes(node *yaml.Node) int {
+	modes := 0
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		switch key := node.Content[i].Value; {
+		case isBodyMode[key]:
+			modes++
+		case key != "contentType":
+			return 0
+		}
+	}
+	return modes
+}
+
+// apply sets the body of the given Target, and its Content-Type header
+// unless it has one already.
+func (b *RequestBody) apply(t *Target) error {
+	contentType := b.ContentType
+	switch {
+	case b.isJSON:
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(b.JSON); err != nil {
+			return fmt.Errorf("body: %w", err)
+		}
+		t.Body = unescapeActions(bytes.TrimSuffix(body.Bytes(), []byte("\n")))
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	case b.Raw != nil:
+		t.Body = []byte(*b.Raw)
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+	case b.Form != nil:
+		t.Form = b.Form
+	case b.Multipart != nil:
+		t.Multipart = b.Multipart
+	case b.File != "":
+		t.File = b.File
+	}
+
+	if contentType != "" && t.Header.Get("Content-Type") == "" {
+		t.Header.Set("Content-Type", contentType)
+	}
+	return nil
+}
+
+// body returns the body of the Target's request with the variables of the
+// given Scope substituted in, along with its length, -1 if unknown, and its
+// default Content-Type.
+func (t *Target) body(scope *Scope) (io.Reader, int64, string, error) {
+	switch {
+	case t.Form != nil:
+		form := url.Values{}
+		for k, v := range t.Form {
+			v, err := t.execute(scope, "form/"+k, v)
+			if err != nil {
+				return nil, 0, "", err
+			}
+			if v, err = t.expand(scope, v); err != nil {
+				return nil, 0, "", err
+			}
+			form.Set(k, v)
+		}
+		encoded := form.Encode()
+		return strings.NewReader(encoded), int64(len(encoded)), "application/x-www-form-urlencoded", nil
+
+	case t.Multipart != nil:
+		return t.multipart(scope)
+
+	case t.File != "":
+		path, err := t.expand(scope, t.File)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, "", err
+		}
+		return f, info.Size(), fileType(path), nil
+
+	case len(t.Body) == 0:
+		return nil, 0, "", nil
+
+	case t.templates["body"] != nil || t.vars && bytes.Contains(t.Body, []byte("${")):
+		body, err := t.execute(scope, "body", string(t.Body))
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if body, err = t.expand(scope, body); err != nil {
+			return nil, 0, "", err
+		}
+		return strings.NewReader(body), int64(len(body)), "", nil
+
+	default:
+		return bytes.NewReader(t.Body), int64(len(t.Body)), "", nil
+	}
+}
+
+// multipart streams the multipart body, so that large files aren't held in
+// memory. Fields and files are written in the order of their names.
+func (t *Target) multipart(scope *Scope) (io.Reader, int64, string, error) {
+	m := t.Multipart
+
+	fields := make(map[string]string, len(m.Fields))
+	for k, v := range m.Fields {
+		v, err := t.execute(scope, "multipart/"+k, v)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if v, err = t.expand(scope, v); err != nil {
+			return nil, 0, "", err
+		}
+		fields[k] = v
+	}
+
+	files := make(map[string]string, len(m.Files))
+	for k, path := range m.Files {
+		path, err := t.expand(scope, path)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		files[k] = path
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeMultipart(mw, fields, files))
+	}()
+
+	return pr, -1, mw.FormDataContentType(), nil
+}
+
+func writeMultipart(mw *multipart.Writer, fields, files map[string]string) error {
+	for _, k := range sortedKeys(fields) {
+		if err := mw.WriteField(k, fields[k]); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range sortedKeys(files) {
+		if err := writeFilePart(mw, k, files[k]); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+func writeFilePart(mw *multipart.Writer, field, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr := textproto.MIMEHeader{}
+	hdr.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(field), escapeQuotes(filepath.Base(path))))
+	hdr.Set("Content-Type", fileType(path))
+
+	part, err := mw.CreatePart(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(part, f)
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string { return quoteEscaper.Replace(s) }
+
+// fileType guesses the Content-Type of a file from its extension.
+func fileType(path string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingReader counts the bytes of a request body as they're sent, for
+// bodies whose length isn't known upfront.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
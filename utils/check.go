@@ -0,0 +1,250 @@
+package gogeta
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Check is an assertion on the response of a step. Exactly one of Status,
+// Header, BodyContains, BodyRegex, JSONPath, Schema or MaxLatency must be
+// set:
+//
+//	checks:
+//	  - status: [200, 201]
+//	  - {header: Content-Type, contains: json}
+//	  - bodyContains: '"ok":true'
+//	  - bodyRegex: '"id":"\w+"'
+//	  - {jsonpath: $.data.state, equals: active}
+//	  - {schema: ./schemas/user.json, maxErrors: 3}
+//	  - {maxLatency: 300ms, abort: true}
+//
+// Without a Status check, a response also fails unless its status code is
+// within 200-399. A Status check decides which status codes are accepted
+// instead, so that e.g. an expected 404 counts as a success.
+type Check struct {
+	// Name identifies the check in results and reports. It defaults to a
+	// description of the check.
+	Name string `json:"name,omitempty" yaml:"name"`
+	// Status lists the accepted status codes.
+	Status []int `json:"status,omitempty" yaml:"status"`
+	// Header is the name of a response header compared with Equals or
+	// Contains.
+	Header string `json:"header,omitempty" yaml:"header"`
+	// BodyContains must be part of the response body.
+	BodyContains string `json:"bodyContains,omitempty" yaml:"bodyContains"`
+	// BodyRegex must match the response body.
+	BodyRegex string `json:"bodyRegex,omitempty" yaml:"bodyRegex"`
+	// JSONPath addresses a value in a JSON response body compared with
+	// Equals.
+	JSONPath string `json:"jsonpath,omitempty" yaml:"jsonpath"`
+	// Equals is the expected value of Header or JSONPath.
+	Equals *string `json:"equals,omitempty" yaml:"equals"`
+	// Contains must be part of the value of Header.
+	Contains *string `json:"contains,omitempty" yaml:"contains"`
+	// Schema is the path of a JSON Schema file the response body must be
+	// valid against.
+	Schema string `json:"schema,omitempty" yaml:"schema"`
+	// MaxErrors is the number of schema violations recorded in the Result
+	// of a response failing Schema, 5 by default.
+	MaxErrors int `json:"maxErrors,omitempty" yaml:"maxErrors"`
+	// MaxLatency is the longest the step may take.
+	MaxLatency time.Duration `json:"maxLatency,omitempty" yaml:"maxLatency"`
+	// Abort skips the remaining steps of the iteration if the check fails.
+	Abort bool `json:"abort,omitempty" yaml:"abort"`
+
+	re     *regexp.Regexp
+	path   jsonPath
+	schema *Schema
+}
+
+// defaultMaxErrors is the default number of schema violations recorded in
+// a Result.
+const defaultMaxErrors = 5
+
+// CheckResult is the outcome of a Check on a response.
+type CheckResult struct {
+	Name string `json:"name"`
+	Pass bool   `json:"pass"`
+}
+
+// A CheckError is the error of a step whose response failed a Check.
+type CheckError struct {
+	Check string
+}
+
+func (e *CheckError) Error() string { return fmt.Sprintf("check %q failed", e.Check) }
+
+// compile checks the Check is well formed, names it and prepares its
+// expression so it isn't parsed again on every response.
+func (c *Check) compile() (err error) {
+	kinds := 0
+	for _, set := range []bool{len(c.Status) > 0, c.Header != "", c.BodyContains != "", c.BodyRegex != "", c.JSONPath != "", c.Schema != "", c.MaxLatency > 0} {
+		if set {
+			kinds++
+		}
+	}
+
+	switch {
+	case kinds != 1:
+		return fmt.Errorf("check needs exactly one of status, header, bodyContains, bodyRegex, jsonpath, schema or maxLatency")
+	case c.Header != "" && (c.Equals == nil) == (c.Contains == nil):
+		return fmt.Errorf("header check of %q needs exactly one of equals or contains", c.Header)
+	case c.JSONPath != "" && c.Equals == nil:
+		return fmt.Errorf("jsonpath check of %s needs equals", c.JSONPath)
+	case c.Header == "" && c.Contains != nil:
+		return fmt.Errorf("contains is only valid in header checks")
+	case c.Header == "" && c.JSONPath == "" && c.Equals != nil:
+		return fmt.Errorf("equals is only valid in header and jsonpath checks")
+	case c.Schema == "" && c.MaxErrors != 0:
+		return fmt.Errorf("maxErrors is only valid in schema checks")
+	case c.MaxErrors < 0:
+		return fmt.Errorf("negative maxErrors %d", c.MaxErrors)
+	case c.BodyRegex != "":
+		c.re, err = regexp.Compile(c.BodyRegex)
+	case c.JSONPath != "":
+		c.path, err = compileJSONPath(c.JSONPath)
+	case c.Schema != "":
+		c.schema, err = LoadSchema(c.Schema)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.Name == "" {
+		c.Name = c.describe()
+	}
+	return nil
+}
+
+func (c *Check) describe() string {
+	switch {
+	case len(c.Status) > 0:
+		codes := make([]string, len(c.Status))
+		for i, code := range c.Status {
+			codes[i] = strconv.Itoa(code)
+		}
+		return "status in " + strings.Join(codes, ",")
+	case c.Header != "" && c.Equals != nil:
+		return fmt.Sprintf("header %s == %s", c.Header, *c.Equals)
+	case c.Header != "":
+		return fmt.Sprintf("header %s contains %s", c.Header, *c.Contains)
+	case c.BodyContains != "":
+		return "body contains " + c.BodyContains
+	case c.BodyRegex != "":
+		return "body matches " + c.BodyRegex
+	case c.JSONPath != "":
+		return fmt.Sprintf("%s == %s", c.JSONPath, *c.Equals)
+	case c.Schema != "":
+		return "body valid against " + c.Schema
+	default:
+		return "latency <= " + c.MaxLatency.String()
+	}
+}
+
+// check runs the Check against a response, returning the violations of its
+// Schema if it has one.
+func (c *Check) check(code uint16, hdr http.Header, body []byte, latency time.Duration) (bool, []string) {
+	switch {
+	case c.Schema != "":
+		violations := c.validate(body)
+		return len(violations) == 0, violations
+
+	case len(c.Status) > 0:
+		for _, s := range c.Status {
+			if int(code) == s {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case c.Header != "":
+		v := hdr.Get(c.Header)
+		if c.Equals != nil {
+			return v == *c.Equals, nil
+		}
+		return strings.Contains(v, *c.Contains), nil
+
+	case c.BodyContains != "":
+		return bytes.Contains(body, []byte(c.BodyContains)), nil
+
+	case c.BodyRegex != "":
+		return c.re.Match(body), nil
+
+	case c.JSONPath != "":
+		doc, err := decodeJSON(body)
+		if err != nil {
+			return false, nil
+		}
+		v, ok := c.path.Lookup(doc)
+		return ok && jsonString(v) == *c.Equals, nil
+
+	default:
+		return latency <= c.MaxLatency, nil
+	}
+}
+
+// validate returns up to MaxErrors violations of the Check's Schema by the
+// given response body.
+func (c *Check) validate(body []byte) []string {
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return []string{"$: invalid JSON: " + err.Error()}
+	}
+
+	max := c.MaxErrors
+	if max == 0 {
+		max = defaultMaxErrors
+	}
+	return c.schema.Validate(doc, max)
+}
+
+// checksStatus reports whether one of the given Checks is a Status check.
+func checksStatus(cs []Check) bool {
+	for _, c := range cs {
+		if len(c.Status) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// runChecks runs the given Checks against a response, recording their
+// outcome and any schema violations in the Result. The first failed Check
+// becomes the Result's error, which is also returned if any failed Check
+// aborts the iteration.
+func runChecks(cs []Check, res *Result, hdr http.Header, latency time.Duration) error {
+	var (
+		failed *CheckError
+		abort  bool
+	)
+	for i := range cs {
+		c := &cs[i]
+		pass, violations := c.check(res.Code, hdr, res.Body, latency)
+		res.SchemaErrors = append(res.SchemaErrors, violations...)
+		res.Checks = append(res.Checks, CheckResult{Name: c.Name, Pass: pass})
+		if pass {
+			continue
+		}
+		if failed == nil {
+			failed = &CheckError{Check: c.Name}
+		}
+		abort = abort || c.Abort
+	}
+
+	if failed == nil {
+		return nil
+	}
+
+	if res.Error == "" {
+		res.Error = failed.Error()
+	}
+	if abort {
+		return failed
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package gogeta
+
+import (
+	"fmt"
+	"strings"
+)
+
+// APIVersion is the current apiVersion of YAML plans.
+const APIVersion = "v1"
+
+// upgraders turn a plan of an older apiVersion into one of the current
+// APIVersion, returning a description of every change they made.
+var upgraders = map[string]func(*Config) []string{
+	"v0.1b": upgradeV01b,
+}
+
+// upgrade checks the apiVersion of the Config, upgrading it to the current
+// APIVersion if it's an older one.
+func (c *Config) upgrade() error {
+	if c.ApiVersion == APIVersion {
+		return nil
+	}
+
+	up, ok := upgraders[c.ApiVersion]
+	if !ok {
+		return fmt.Errorf("%w %q, plans must declare apiVersion: %s", ErrAPIVersion, c.ApiVersion, APIVersion)
+	}
+
+	c.Warnings = append(c.Warnings, fmt.Sprintf("apiVersion %s is deprecated, upgrading the plan to %s", c.ApiVersion, APIVersion))
+	c.Warnings = append(c.Warnings, up(c)...)
+	c.ApiVersion = APIVersion
+
+	return nil
+}
+
+// upgradeV01b rewrites the %s placeholders of v0.1b URLs into variable
+// references. The placeholders were meant to be filled with the matching
+// $var of params, but were always filled with the value cached as txn_uuid.
+// Placeholders with a matching param are upgraded to that param, the others
+// to ${txn_uuid}.
+func upgradeV01b(c *Config) []string {
+	var changes []string
+	for i := range c.TargetPlan {
+		plan := &c.TargetPlan[i]
+		for j := range plan.Targets {
+			run := &plan.Targets[j].Run
+			if !strings.Contains(run.Url, "%s") {
+				continue
+			}
+
+			parts := strings.Split(run.Url, "%s")
+			var url strings.Builder
+			for k, part := range parts {
+				if k > 0 {
+					ref := "${txn_uuid}"
+					if k <= len(run.Params) {
+						ref = paramRef(run.Params[k-1])
+					}
+					url.WriteString(ref)
+				}
+				url.WriteString(part)
+			}
+
+			changes = append(changes, fmt.Sprintf("plan %q, target %d: url %q is now %q", plan.Name, j, run.Url, url.String()))
+			run.Url, run.Params = url.String(), nil
+		}
+	}
+	return changes
+}
+
+// paramRef turns a param into the text substituted for its placeholder: a
+// $var reference becomes ${var}, anything else is taken literally.
+func paramRef(param string) string {
+	if name, ok := paramVar(param); ok {
+		return "${" + name + "}"
+	}
+	return param
+}
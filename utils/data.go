@@ -0,0 +1,201 @@
+package gogeta
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DataSource is the data section of a plan: a CSV or JSON file whose rows
+// feed the plan's iterations, one row per iteration. The fields of a row
+// are set as variables of the iteration, named after the CSV header or the
+// JSON object keys.
+//
+//	data: {file: users.csv, strategy: unique-per-VU}
+type DataSource struct {
+	// File is the path of the data. CSV files must start with a header and
+	// JSON files hold an array of objects.
+	File string `yaml:"file"`
+	// Format is csv or json, guessed from the extension of File if unset.
+	Format string `yaml:"format"`
+	// Strategy picks the row of each iteration, sequential by default.
+	Strategy string `yaml:"strategy"`
+}
+
+// Strategies of a Feeder.
+const (
+	// DataSequential hands out the rows in order, starting over once they
+	// have all been used.
+	DataSequential = "sequential"
+	// DataRandom hands out a random row to every iteration.
+	DataRandom = "random"
+	// DataUniquePerVU gives every worker of the plan's scenario a row of its
+	// own, which it uses for all its iterations. There must be a row for
+	// every worker.
+	DataUniquePerVU = "unique-per-VU"
+	// DataStopWhenExhausted hands out the rows in order and stops the plan's
+	// scenario once they have all been used.
+	DataStopWhenExhausted = "stop-when-exhausted"
+)
+
+// ErrDataExhausted is returned by a Feeder once all its rows are used with
+// the DataStopWhenExhausted strategy.
+var ErrDataExhausted = errors.New("data exhausted")
+
+// A Feeder hands out the rows of a DataSource to iterations. It's safe for
+// concurrent use.
+type Feeder struct {
+	rows     []map[string]string
+	strategy string
+
+	mu   sync.Mutex
+	next int
+}
+
+// NewFeeder returns a Feeder of the given rows using the given strategy.
+func NewFeeder(rows []map[string]string, strategy string) (*Feeder, error) {
+	switch strategy {
+	case "":
+		strategy = DataSequential
+	case DataSequential, DataRandom, DataUniquePerVU, DataStopWhenExhausted:
+	default:
+		return nil, fmt.Errorf("data: unknown strategy %q", strategy)
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("data: no rows")
+	}
+
+	return &Feeder{rows: rows, strategy: strategy}, nil
+}
+
+// Feeder loads the rows of the DataSource.
+func (d *DataSource) Feeder() (*Feeder, error) {
+	format := d.Format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(d.File)), ".")
+	}
+
+	f, err := os.Open(d.File)
+	if err != nil {
+		return nil, fmt.Errorf("data: %w", err)
+	}
+	defer f.Close()
+
+	var rows []map[string]string
+	switch format {
+	case "csv":
+		rows, err = readCSVRows(f)
+	case "json":
+		rows, err = readJSONRows(f)
+	default:
+		return nil, fmt.Errorf("data: unknown format %q of %s, use csv or json", format, d.File)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("data: %s: %w", d.File, err)
+	}
+
+	return NewFeeder(rows, d.Strategy)
+}
+
+// Columns returns the names of the fields of the Feeder's rows.
+func (f *Feeder) Columns() []string {
+	seen := map[string]bool{}
+	var cols []string
+	for _, row := range f.rows {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				cols = append(cols, k)
+			}
+		}
+	}
+	return cols
+}
+
+// Row returns the row of the next iteration of the given worker.
+func (f *Feeder) Row(vu uint64) (map[string]string, error) {
+	switch f.strategy {
+	case DataRandom:
+		return f.rows[rand.Intn(len(f.rows))], nil
+	case DataUniquePerVU:
+		if vu == 0 || vu > uint64(len(f.rows)) {
+			return nil, fmt.Errorf("data: no row for worker %d, there are only %d rows", vu, len(f.rows))
+		}
+		return f.rows[vu-1], nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.next == len(f.rows) {
+		if f.strategy == DataStopWhenExhausted {
+			return nil, ErrDataExhausted
+		}
+		f.next = 0
+	}
+
+	row := f.rows[f.next]
+	f.next++
+	return row, nil
+}
+
+func readCSVRows(r io.Reader) ([]map[string]string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header")
+	}
+
+	header := records[0]
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			row[name] = rec[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readJSONRows(r io.Reader) ([]map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, ok := doc.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an array of objects")
+	}
+
+	rows := make([]map[string]string, 0, len(objs))
+	for i, o := range objs {
+		obj, ok := o.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("element %d isn't an object", i)
+		}
+		row := make(map[string]string, len(obj))
+		for k, v := range obj {
+			row[k] = jsonString(v)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
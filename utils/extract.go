@@ -0,0 +1,181 @@
+package gogeta
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Scope holds the variables shared by the steps of a single iteration of a
+// chained Target. Values extracted from a response are stored in it and
+// substituted into the requests of subsequent steps.
+type Scope struct {
+	Vars map[string]string
+	// Seq is the sequence number of the iteration.
+	Seq uint64
+	// VU is the number of the worker running the iteration within its
+	// scenario, from 1.
+	VU uint64
+
+	// templates are the request templates of the worker running the
+	// iteration.
+	templates *templates
+}
+
+// NewScope returns an empty Scope.
+func NewScope() *Scope {
+	return &Scope{Vars: map[string]string{}}
+}
+
+// Expand replaces every ${name} reference in the given string with the value
+// of the variable of the same name. Referencing an unset variable is an error.
+func (s *Scope) Expand(in string) (string, error) {
+	if !strings.Contains(in, "${") {
+		return in, nil
+	}
+
+	var (
+		out  strings.Builder
+		rest = in
+	)
+
+	for {
+		start := strings.Index(rest, "${")
+		if start == -1 {
+			out.WriteString(rest)
+			return out.String(), nil
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated variable reference in %q", in)
+		}
+
+		name := rest[start+2 : start+end]
+		val, ok := s.Vars[name]
+		if !ok {
+			return "", fmt.Errorf("%q: %w", name, ErrUnsetVar)
+		}
+
+		out.WriteString(rest[:start])
+		out.WriteString(val)
+		rest = rest[start+end+1:]
+	}
+}
+
+// bind copies the variables a Target asks for under their local names.
+func (s *Scope) bind(bindings map[string]string) error {
+	for local, key := range bindings {
+		val, ok := s.Vars[key]
+		if !ok {
+			return fmt.Errorf("cache-get %q: %w", key, ErrUnsetVar)
+		}
+		s.Vars[local] = val
+	}
+	return nil
+}
+
+// An ExtractError is returned when a value can't be extracted from a
+// response, e.g. because the body isn't JSON or the addressed member is
+// missing.
+type ExtractError struct {
+	Var string
+	Err error
+}
+
+func (e *ExtractError) Error() string { return fmt.Sprintf("extract %q: %v", e.Var, e.Err) }
+
+// Unwrap returns the underlying error.
+func (e *ExtractError) Unwrap() error { return e.Err }
+
+// An Extractor pulls a single value out of a response and stores it in the
+// iteration's Scope under Var. Exactly one of JSONPath, Header or Regex must
+// be set.
+type Extractor struct {
+	// Var is the name the extracted value is stored under.
+	Var string `json:"var"`
+	// JSONPath addresses a value in a JSON response body, e.g. $.data.id.
+	JSONPath string `json:"jsonpath,omitempty"`
+	// Header is the name of a response header.
+	Header string `json:"header,omitempty"`
+	// Regex is matched against the response body. The first capture group
+	// is extracted if there is one, otherwise the whole match.
+	Regex string `json:"regex,omitempty"`
+
+	path jsonPath
+	re   *regexp.Regexp
+}
+
+// compile checks the Extractor is well formed and prepares its expression
+// so it isn't parsed again on every response.
+func (x *Extractor) compile() (err error) {
+	sources := 0
+	for _, src := range []string{x.JSONPath, x.Header, x.Regex} {
+		if src != "" {
+			sources++
+		}
+	}
+
+	switch {
+	case x.Var == "":
+		return fmt.Errorf("extractor has no variable name")
+	case sources != 1:
+		return fmt.Errorf("extractor for %q needs exactly one of jsonpath, header or regex", x.Var)
+	case x.JSONPath != "":
+		x.path, err = compileJSONPath(x.JSONPath)
+	case x.Regex != "":
+		x.re, err = regexp.Compile(x.Regex)
+	}
+
+	return err
+}
+
+// extract runs all the given Extractors against a response, storing the
+// extracted values in the Scope. The body is decoded as JSON at most once.
+func (s *Scope) extract(xs []Extractor, hdr http.Header, body []byte) error {
+	var (
+		doc     any
+		decoded bool
+	)
+
+	for i := range xs {
+		x := &xs[i]
+		switch {
+		case x.Header != "":
+			vs, ok := hdr[http.CanonicalHeaderKey(x.Header)]
+			if !ok || len(vs) == 0 {
+				return &ExtractError{Var: x.Var, Err: fmt.Errorf("header %q not found", x.Header)}
+			}
+			s.Vars[x.Var] = vs[0]
+
+		case x.Regex != "":
+			m := x.re.FindSubmatch(body)
+			switch {
+			case m == nil:
+				return &ExtractError{Var: x.Var, Err: fmt.Errorf("regex %q didn't match", x.Regex)}
+			case len(m) > 1:
+				s.Vars[x.Var] = string(m[1])
+			default:
+				s.Vars[x.Var] = string(m[0])
+			}
+
+		case x.JSONPath != "":
+			if !decoded {
+				var err error
+				if doc, err = decodeJSON(body); err != nil {
+					return &ExtractError{Var: x.Var, Err: fmt.Errorf("body isn't valid JSON: %w", err)}
+				}
+				decoded = true
+			}
+
+			v, ok := x.path.Lookup(doc)
+			if !ok {
+				return &ExtractError{Var: x.Var, Err: fmt.Errorf("%s not found in body", x.JSONPath)}
+			}
+			s.Vars[x.Var] = jsonString(v)
+		}
+	}
+
+	return nil
+}
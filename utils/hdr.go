@@ -0,0 +1,170 @@
+package gogeta
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// DefaultPrecision is the default number of significant digits of the
+// latencies recorded in Metrics.
+const DefaultPrecision = 3
+
+// hdrHistogram is a High Dynamic Range histogram of positive integer values,
+// nanosecond latencies here, recorded with a fixed number of significant
+// digits. Its memory only depends on the precision and on the magnitude of
+// the largest value recorded, never on the number of values.
+//
+// Values are grouped in buckets covering powers of two, each split in
+// sub-buckets as wide as the precision allows, as described at
+// http://hdrhistogram.org.
+type hdrHistogram struct {
+	precision int
+
+	// subBucketHalfCountMag is log2 of half the number of sub-buckets.
+	subBucketHalfCountMag uint
+	subBucketHalfCount    int64
+	subBucketMask         int64
+
+	counts []uint64
+	total  uint64
+}
+
+// newHDRHistogram returns an hdrHistogram keeping the given number of
+// significant digits, from 1 to 5.
+func newHDRHistogram(precision int) (*hdrHistogram, error) {
+	if precision < 1 || precision > 5 {
+		return nil, fmt.Errorf("precision %d isn't between 1 and 5 significant digits", precision)
+	}
+
+	// The sub-buckets must tell apart all the values with as many digits,
+	// i.e. up to 2*10^precision with a unit resolution.
+	largest := 2 * int64(math.Pow10(precision))
+	subBucketCountMag := uint(bits.Len64(uint64(largest - 1)))
+
+	return &hdrHistogram{
+		precision:             precision,
+		subBucketHalfCountMag: subBucketCountMag - 1,
+		subBucketHalfCount:    1 << (subBucketCountMag - 1),
+		subBucketMask:         1<<subBucketCountMag - 1,
+	}, nil
+}
+
+// index returns the position in counts of the given value.
+func (h *hdrHistogram) index(v int64) int {
+	bucket := bits.Len64(uint64(v|h.subBucketMask)) - int(h.subBucketHalfCountMag+1)
+	sub := v >> uint(bucket)
+	return (bucket+1)<<h.subBucketHalfCountMag + int(sub-h.subBucketHalfCount)
+}
+
+// highest returns the highest value counted at the given position in counts.
+func (h *hdrHistogram) highest(i int) int64 {
+	bucket := i>>h.subBucketHalfCountMag - 1
+	sub := int64(i)&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucket < 0 {
+		sub -= h.subBucketHalfCount
+		bucket = 0
+	}
+	return (sub+1)<<uint(bucket) - 1
+}
+
+// Record adds n occurrences of the given value. Negative values count as
+// zero.
+func (h *hdrHistogram) Record(v int64, n uint64) {
+	if v < 0 {
+		v = 0
+	}
+	i := h.index(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, i+1-len(h.counts))...)
+	}
+	h.counts[i] += n
+	h.total += n
+}
+
+// Quantile returns the value at the given quantile, between 0 and 1: the
+// value of rank q*total rounded to the nearest integer, like HdrHistogram
+// does. It's the highest value equivalent to the recorded one at the
+// histogram's precision.
+func (h *hdrHistogram) Quantile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := uint64(q*float64(h.total) + 0.5)
+	switch {
+	case rank < 1:
+		rank = 1
+	case rank > h.total:
+		rank = h.total
+	}
+
+	var seen uint64
+	for i, c := range h.counts {
+		if seen += c; seen >= rank {
+			return h.highest(i)
+		}
+	}
+	return h.highest(len(h.counts) - 1)
+}
+
+// Merge adds the values recorded by the given hdrHistogram.
+func (h *hdrHistogram) Merge(o *hdrHistogram) {
+	if o.precision == h.precision {
+		if len(o.counts) > len(h.counts) {
+			h.counts = append(h.counts, make([]uint64, len(o.counts)-len(h.counts))...)
+		}
+		for i, c := range o.counts {
+			h.counts[i] += c
+		}
+		h.total += o.total
+		return
+	}
+
+	for i, c := range o.counts {
+		if c > 0 {
+			h.Record(o.highest(i), c)
+		}
+	}
+}
+
+// hdrJSON is the JSON form of an hdrHistogram: its precision and, for each
+// sub-bucket which counted values, its highest value and count.
+type hdrJSON struct {
+	Precision int         `json:"precision"`
+	Counts    [][2]uint64 `json:"counts"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (h *hdrHistogram) MarshalJSON() ([]byte, error) {
+	v := hdrJSON{Precision: h.precision, Counts: [][2]uint64{}}
+	for i, c := range h.counts {
+		if c > 0 {
+			v.Counts = append(v.Counts, [2]uint64{uint64(h.highest(i)), c})
+		}
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (h *hdrHistogram) UnmarshalJSON(data []byte) error {
+	var v hdrJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	decoded, err := newHDRHistogram(v.Precision)
+	if err != nil {
+		return err
+	}
+	for _, c := range v.Counts {
+		if c[0] > math.MaxInt64 {
+			return fmt.Errorf("histogram value %d overflows", c[0])
+		}
+		decoded.Record(int64(c[0]), c[1])
+	}
+
+	*h = *decoded
+	return nil
+}
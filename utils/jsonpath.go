@@ -0,0 +1,135 @@
+package gogeta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled JSONPath expression. Only the subset needed to
+// address a single value is supported: child members ($.a.b or $['a']) and
+// array indices ($.a[0], negative indices count from the end). The leading
+// $ is optional, so "id" and "data.id" are valid paths too.
+type jsonPath []pathSegment
+
+type pathSegment struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+func compileJSONPath(path string) (jsonPath, error) {
+	p := strings.TrimSpace(path)
+	switch {
+	case p == "" || p == "$":
+		return jsonPath{}, nil
+	case strings.HasPrefix(p, "$"):
+		p = p[1:]
+	case p[0] != '[':
+		p = "." + p
+	}
+
+	var segs jsonPath
+	for len(p) > 0 {
+		switch p[0] {
+		case '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("jsonpath %q: empty member name", path)
+			}
+			segs = append(segs, pathSegment{key: p[:end]})
+			p = p[end:]
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("jsonpath %q: unterminated [", path)
+			}
+			sel := strings.TrimSpace(p[1:end])
+			p = p[end+1:]
+
+			if len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0] {
+				segs = append(segs, pathSegment{key: sel[1 : len(sel)-1]})
+				continue
+			}
+
+			i, err := strconv.Atoi(sel)
+			if err != nil {
+				return nil, fmt.Errorf("jsonpath %q: bad index %q", path, sel)
+			}
+			segs = append(segs, pathSegment{index: i, isIdx: true})
+		default:
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q", path, p[0])
+		}
+	}
+
+	return segs, nil
+}
+
+// Lookup walks the given decoded JSON document and returns the addressed
+// value, or false if it doesn't exist.
+func (jp jsonPath) Lookup(doc any) (any, bool) {
+	cur := doc
+	for _, seg := range jp {
+		if seg.isIdx {
+			arr, ok := cur.([]any)
+			if !ok {
+				return nil, false
+			}
+			i := seg.index
+			if i < 0 {
+				i += len(arr)
+			}
+			if i < 0 || i >= len(arr) {
+				return nil, false
+			}
+			cur = arr[i]
+			continue
+		}
+
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[seg.key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// decodeJSON decodes the given document preserving number literals, so that
+// large integer IDs survive being turned back into strings.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// jsonString renders a decoded JSON value as a string. Strings are returned
+// verbatim and everything else in its compact JSON form.
+func jsonString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
@@ -0,0 +1,614 @@
+package gogeta
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics holds metrics computed out of a stream of Results which are used
+// in some of the Reporters.
+type Metrics struct {
+	// Latencies holds computed request latency metrics, the service time of
+	// the requests from when they were actually sent.
+	Latencies LatencyMetrics `json:"latencies"`
+	// ResponseTimes holds the latency metrics of the requests counted from
+	// when their pacer meant them to be sent, which includes the time they
+	// waited for a worker under overload. It's the same as Latencies for
+	// attacks without a pacer.
+	ResponseTimes LatencyMetrics `json:"response_times"`
+	// Histogram, only if requested
+	Histogram *Histogram `json:"buckets,omitempty"`
+	// BytesIn holds computed incoming byte metrics.
+	BytesIn ByteMetrics `json:"bytes_in"`
+	// BytesOut holds computed outgoing byte metrics.
+	BytesOut ByteMetrics `json:"bytes_out"`
+	// Earliest is the earliest timestamp in a Result set.
+	Earliest time.Time `json:"earliest"`
+	// Latest is the latest timestamp in a Result set.
+	Latest time.Time `json:"latest"`
+	// End is the latest timestamp in a Result set plus its latency.
+	End time.Time `json:"end"`
+	// Duration is the duration of the attack.
+	Duration time.Duration `json:"duration"`
+	// Wait is the extra time waiting for responses from targets.
+	Wait time.Duration `json:"wait"`
+	// Requests is the total number of requests executed.
+	Requests uint64 `json:"requests"`
+	// Rate is the rate of sent requests per second.
+	Rate float64 `json:"rate"`
+	// Throughput is the rate of successful requests per second.
+	Throughput float64 `json:"throughput"`
+	// Success is the percentage of non-error responses.
+	Success float64 `json:"success"`
+	// Successes is the number of non-error responses.
+	Successes uint64 `json:"successes"`
+	// StatusCodes is a histogram of the responses' status codes.
+	StatusCodes map[string]int `json:"status_codes"`
+	// Workers is the largest number of workers the attack had started. When
+	// it's at the configured maximum, the rate may have been limited by the
+	// attacker rather than by the targets.
+	Workers uint64 `json:"workers"`
+	// Errors is a set of unique errors returned by the targets during the
+	// attack, bounded to the first maxErrors.
+	Errors []string `json:"errors"`
+	// OtherErrors is the number of failed Results whose error isn't in
+	// Errors as it came after maxErrors others.
+	OtherErrors uint64 `json:"other_errors,omitempty"`
+	// ErrorCounts counts the failed Results of each error of Errors.
+	ErrorCounts map[string]uint64 `json:"error_counts,omitempty"`
+	// Plans holds the metrics of the iterations of each plan.
+	Plans []*PlanMetrics `json:"plans,omitempty"`
+	// Steps holds the metrics of each step of chained Targets, the top level
+	// metrics only account for whole iterations.
+	Steps []*StepMetrics `json:"steps,omitempty"`
+	// Checks holds the number of passes and failures of each check.
+	Checks []*CheckMetrics `json:"checks,omitempty"`
+	// SchemaFailures is the number of responses which failed a JSON Schema
+	// check, apart from transport errors.
+	SchemaFailures uint64 `json:"schema_failures,omitempty"`
+	// SchemaErrors is a set of unique schema violations of the responses,
+	// bounded to the first maxSchemaErrors.
+	SchemaErrors []string `json:"schema_errors,omitempty"`
+
+	errors map[string]struct{}
+	plans  map[string]*PlanMetrics
+	steps  map[stepKey]*StepMetrics
+	checks map[checkKey]*CheckMetrics
+	schema map[string]struct{}
+}
+
+// PlanMetrics holds the Metrics of the iterations of a single plan.
+type PlanMetrics struct {
+	Plan string `json:"plan"`
+	// Share is the fraction of all iterations which ran this plan.
+	Share float64 `json:"share"`
+	Metrics
+}
+
+// StepMetrics holds the Metrics of a single step of a chained plan.
+type StepMetrics struct {
+	Plan string `json:"plan"`
+	Step int    `json:"step"`
+	Name string `json:"name"`
+	Metrics
+}
+
+type stepKey struct {
+	plan string
+	step int
+}
+
+// CheckMetrics counts the outcomes of a single check of a plan's step.
+type CheckMetrics struct {
+	Plan     string `json:"plan"`
+	Step     int    `json:"step"`
+	StepName string `json:"step_name"`
+	Name     string `json:"name"`
+	Passes   uint64 `json:"passes"`
+	Fails    uint64 `json:"fails"`
+	// Ratio is the fraction of the check's runs which passed.
+	Ratio float64 `json:"ratio"`
+}
+
+type checkKey struct {
+	stepKey
+	name string
+}
+
+// maxSchemaErrors bounds the unique schema violations kept by Metrics, as
+// they may differ by array index or value on every response.
+const maxSchemaErrors = 100
+
+// maxErrors bounds the unique errors kept by Metrics, as they may embed a
+// URL which differs on every request.
+const maxErrors = 100
+
+// Add implements the Add method of the Report interface by adding the given
+// Result to Metrics.
+func (m *Metrics) Add(r *Result) {
+	m.init()
+
+	for _, c := range r.Checks {
+		cm := m.check(r.Plan, r.Step, r.StepName, c.Name)
+		if c.Pass {
+			cm.Passes++
+		} else {
+			cm.Fails++
+		}
+	}
+
+	if len(r.SchemaErrors) > 0 {
+		m.SchemaFailures++
+		m.schemaErrors(r.SchemaErrors)
+	}
+
+	if r.Step > 0 {
+		m.step(r.Plan, r.Step, r.StepName).add(r)
+		return
+	}
+
+	if r.Plan != "" {
+		m.plan(r.Plan).add(r)
+	}
+
+	m.add(r)
+}
+
+// Merge adds the Results aggregated by the given Metrics, e.g. those of
+// another results file or of another node of a distributed attack, which
+// may have been decoded from a JSON report. Close must be called again to
+// update the summary metrics.
+func (m *Metrics) Merge(o *Metrics) {
+	m.init()
+
+	for _, ocm := range o.Checks {
+		cm := m.check(ocm.Plan, ocm.Step, ocm.StepName, ocm.Name)
+		cm.Passes += ocm.Passes
+		cm.Fails += ocm.Fails
+	}
+
+	m.SchemaFailures += o.SchemaFailures
+	m.schemaErrors(o.SchemaErrors)
+
+	for _, osm := range o.Steps {
+		m.step(osm.Plan, osm.Step, osm.Name).merge(&osm.Metrics)
+	}
+
+	for _, opm := range o.Plans {
+		m.plan(opm.Plan).merge(&opm.Metrics)
+	}
+
+	m.merge(o)
+}
+
+func (m *Metrics) check(plan string, step int, stepName, name string) *CheckMetrics {
+	k := checkKey{stepKey: stepKey{plan: plan, step: step}, name: name}
+	cm, ok := m.checks[k]
+	if !ok {
+		cm = &CheckMetrics{Plan: plan, Step: step, StepName: stepName, Name: name}
+		m.checks[k] = cm
+		m.Checks = append(m.Checks, cm)
+	}
+	return cm
+}
+
+func (m *Metrics) step(plan string, step int, name string) *StepMetrics {
+	k := stepKey{plan: plan, step: step}
+	sm, ok := m.steps[k]
+	if !ok {
+		sm = &StepMetrics{Plan: plan, Step: step, Name: name}
+		sm.Latencies.Precision = m.Latencies.Precision
+		sm.ResponseTimes.Precision = m.Latencies.Precision
+		sm.init()
+		m.steps[k] = sm
+		m.Steps = append(m.Steps, sm)
+	}
+	return sm
+}
+
+func (m *Metrics) plan(plan string) *PlanMetrics {
+	pm, ok := m.plans[plan]
+	if !ok {
+		pm = &PlanMetrics{Plan: plan}
+		pm.Latencies.Precision = m.Latencies.Precision
+		pm.ResponseTimes.Precision = m.Latencies.Precision
+		pm.init()
+		m.plans[plan] = pm
+		m.Plans = append(m.Plans, pm)
+	}
+	return pm
+}
+
+func (m *Metrics) schemaErrors(errs []string) {
+	for _, e := range errs {
+		if _, ok := m.schema[e]; !ok && len(m.SchemaErrors) < maxSchemaErrors {
+			m.schema[e] = struct{}{}
+			m.SchemaErrors = append(m.SchemaErrors, e)
+		}
+	}
+}
+
+func (m *Metrics) add(r *Result) {
+	m.Requests++
+	m.StatusCodes[strconv.Itoa(int(r.Code))]++
+	m.BytesOut.Total += r.BytesOut
+	m.BytesIn.Total += r.BytesIn
+
+	m.Latencies.Add(r.Latency)
+	m.ResponseTimes.Add(r.ResponseTime())
+
+	if r.Workers > m.Workers {
+		m.Workers = r.Workers
+	}
+
+	if m.Earliest.IsZero() || m.Earliest.After(r.Timestamp) {
+		m.Earliest = r.Timestamp
+	}
+
+	if r.Timestamp.After(m.Latest) {
+		m.Latest = r.Timestamp
+	}
+
+	if end := r.End(); end.After(m.End) {
+		m.End = end
+	}
+
+	// A hit only counts as successful if nothing went wrong with it, even
+	// when the status code looks fine, e.g. a response value failed to be
+	// extracted for the next step of a chain.
+	if r.Code >= 200 && r.Code < 400 && r.Error == "" {
+		m.Successes++
+	}
+
+	if r.Error != "" {
+		m.countError(r.Error, 1)
+	}
+
+	if m.Histogram != nil {
+		m.Histogram.Add(r)
+	}
+}
+
+// countError counts n failed Results with the given error, which is only
+// told apart from the others while there are fewer than maxErrors.
+func (m *Metrics) countError(e string, n uint64) {
+	if _, ok := m.errors[e]; !ok {
+		if len(m.Errors) >= maxErrors {
+			m.OtherErrors += n
+			return
+		}
+		m.errors[e] = struct{}{}
+		m.Errors = append(m.Errors, e)
+	}
+	m.ErrorCounts[e] += n
+}
+
+func (m *Metrics) merge(o *Metrics) {
+	o.init()
+
+	m.Requests += o.Requests
+	for code, n := range o.StatusCodes {
+		m.StatusCodes[code] += n
+	}
+	m.BytesOut.Total += o.BytesOut.Total
+	m.BytesIn.Total += o.BytesIn.Total
+
+	m.Latencies.Merge(&o.Latencies)
+	m.ResponseTimes.Merge(&o.ResponseTimes)
+
+	if o.Workers > m.Workers {
+		m.Workers = o.Workers
+	}
+
+	if !o.Earliest.IsZero() && (m.Earliest.IsZero() || m.Earliest.After(o.Earliest)) {
+		m.Earliest = o.Earliest
+	}
+
+	if o.Latest.After(m.Latest) {
+		m.Latest = o.Latest
+	}
+
+	if o.End.After(m.End) {
+		m.End = o.End
+	}
+
+	m.Successes += o.Successes
+
+	for _, e := range o.Errors {
+		m.countError(e, o.ErrorCounts[e])
+	}
+	m.OtherErrors += o.OtherErrors
+
+	// Histograms of other buckets can't be merged and are left out.
+	if m.Histogram != nil && o.Histogram != nil {
+		_ = m.Histogram.Merge(o.Histogram)
+	}
+}
+
+// Close implements the Close method of the Report interface by computing
+// derived summary metrics which don't need to be run on every Add call.
+func (m *Metrics) Close() {
+	m.init()
+
+	sort.Slice(m.Steps, func(i, j int) bool {
+		if m.Steps[i].Plan != m.Steps[j].Plan {
+			return m.Steps[i].Plan < m.Steps[j].Plan
+		}
+		return m.Steps[i].Step < m.Steps[j].Step
+	})
+
+	for _, sm := range m.Steps {
+		sm.Close()
+	}
+
+	// Checks keep the order of their definition within a step.
+	sort.SliceStable(m.Checks, func(i, j int) bool {
+		if m.Checks[i].Plan != m.Checks[j].Plan {
+			return m.Checks[i].Plan < m.Checks[j].Plan
+		}
+		return m.Checks[i].Step < m.Checks[j].Step
+	})
+	for _, cm := range m.Checks {
+		cm.Ratio = float64(cm.Passes) / float64(cm.Passes+cm.Fails)
+	}
+
+	sort.Slice(m.Plans, func(i, j int) bool { return m.Plans[i].Plan < m.Plans[j].Plan })
+	for _, pm := range m.Plans {
+		pm.Close()
+		if m.Requests > 0 {
+			pm.Share = float64(pm.Requests) / float64(m.Requests)
+		}
+	}
+
+	if m.Requests == 0 {
+		return
+	}
+
+	m.Duration = m.Latest.Sub(m.Earliest)
+	if secs := m.Duration.Seconds(); secs > 0 {
+		m.Rate = float64(m.Requests) / secs
+	}
+
+	m.Wait = m.End.Sub(m.Latest)
+	if secs := (m.Duration + m.Wait).Seconds(); secs > 0 {
+		m.Throughput = float64(m.Successes) / secs
+	}
+
+	m.BytesIn.Mean = float64(m.BytesIn.Total) / float64(m.Requests)
+	m.BytesOut.Mean = float64(m.BytesOut.Total) / float64(m.Requests)
+	m.Success = float64(m.Successes) / float64(m.Requests)
+	m.Latencies.summarize(m.Requests)
+	m.ResponseTimes.summarize(m.Requests)
+}
+
+// Delayed reports whether some requests were sent later than their pacer
+// intended, so that the ResponseTimes differ from the Latencies.
+func (m *Metrics) Delayed() bool { return m.ResponseTimes.Total != m.Latencies.Total }
+
+// ErrorCount returns the number of Results whose error contains the given
+// text, ignoring case, or of all failed Results if it's empty. Only the
+// errors kept in Errors are matched against the text.
+func (m *Metrics) ErrorCount(text string) uint64 {
+	if text == "" {
+		n := m.OtherErrors
+		for _, count := range m.ErrorCounts {
+			n += count
+		}
+		return n
+	}
+
+	text = strings.ToLower(text)
+
+	var n uint64
+	for e, count := range m.ErrorCounts {
+		if strings.Contains(strings.ToLower(e), text) {
+			n += count
+		}
+	}
+	return n
+}
+
+// encodeHistograms sets whether the latency histograms of the Metrics, and
+// of their plans and steps, are part of their JSON form.
+func (m *Metrics) encodeHistograms(on bool) {
+	m.Latencies.encodeHist, m.ResponseTimes.encodeHist = on, on
+	for _, pm := range m.Plans {
+		pm.encodeHistograms(on)
+	}
+	for _, sm := range m.Steps {
+		sm.encodeHistograms(on)
+	}
+}
+
+// init makes the Metrics ready for use. The indexes of Metrics decoded from
+// JSON are rebuilt out of their exported fields.
+func (m *Metrics) init() {
+	if m.StatusCodes == nil {
+		m.StatusCodes = map[string]int{}
+	}
+
+	if m.errors == nil {
+		m.errors = map[string]struct{}{}
+		for _, e := range m.Errors {
+			m.errors[e] = struct{}{}
+		}
+	}
+
+	if m.ErrorCounts == nil {
+		m.ErrorCounts = map[string]uint64{}
+	}
+
+	if m.Errors == nil {
+		m.Errors = make([]string, 0)
+	}
+
+	if m.plans == nil {
+		m.plans = map[string]*PlanMetrics{}
+		for _, pm := range m.Plans {
+			m.plans[pm.Plan] = pm
+		}
+	}
+
+	if m.steps == nil {
+		m.steps = map[stepKey]*StepMetrics{}
+		for _, sm := range m.Steps {
+			m.steps[stepKey{plan: sm.Plan, step: sm.Step}] = sm
+		}
+	}
+
+	if m.checks == nil {
+		m.checks = map[checkKey]*CheckMetrics{}
+		for _, cm := range m.Checks {
+			m.checks[checkKey{stepKey: stepKey{plan: cm.Plan, step: cm.Step}, name: cm.Name}] = cm
+		}
+	}
+
+	if m.schema == nil {
+		m.schema = map[string]struct{}{}
+		for _, e := range m.SchemaErrors {
+			m.schema[e] = struct{}{}
+		}
+	}
+}
+
+// LatencyMetrics holds computed request latency metrics. Latencies are kept
+// in an HDR histogram, so their quantiles are exact to the given Precision
+// in a memory bound by the largest latency rather than the number of
+// requests. The histogram is only part of the JSON form of LatencyMetrics
+// written by NewMergeableJSONReporter, so that decoded ones can still be
+// merged.
+type LatencyMetrics struct {
+	// Total is the total latency sum of all requests in an attack.
+	Total time.Duration `json:"total"`
+	// Mean is the mean request latency.
+	Mean time.Duration `json:"mean"`
+	// P50 is the 50th percentile request latency.
+	P50 time.Duration `json:"50th"`
+	// P90 is the 90th percentile request latency.
+	P90 time.Duration `json:"90th"`
+	// P95 is the 95th percentile request latency.
+	P95 time.Duration `json:"95th"`
+	// P99 is the 99th percentile request latency.
+	P99 time.Duration `json:"99th"`
+	// Max is the maximum observed request latency.
+	Max time.Duration `json:"max"`
+	// Min is the minimum observed request latency.
+	Min time.Duration `json:"min"`
+	// Precision is the number of significant digits, from 1 to 5, of the
+	// quantiles. It must be set before the first latency is added, and
+	// defaults to DefaultPrecision.
+	Precision int `json:"-"`
+
+	hist *hdrHistogram
+	// encodeHist adds the histogram to the JSON form of the LatencyMetrics.
+	encodeHist bool
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (l LatencyMetrics) MarshalJSON() ([]byte, error) {
+	type plain LatencyMetrics
+	v := struct {
+		plain
+		Histogram *hdrHistogram `json:"histogram,omitempty"`
+	}{plain: plain(l)}
+	if l.encodeHist {
+		v.Histogram = l.hist
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (l *LatencyMetrics) UnmarshalJSON(data []byte) error {
+	type plain LatencyMetrics
+	v := struct {
+		*plain
+		Histogram *hdrHistogram `json:"histogram"`
+	}{plain: (*plain)(l)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	if l.hist = v.Histogram; l.hist != nil {
+		l.Precision = l.hist.precision
+	}
+	return nil
+}
+
+// histogram returns the HDR histogram of the latencies, creating it on first
+// use.
+func (l *LatencyMetrics) histogram() *hdrHistogram {
+	if l.hist == nil {
+		var err error
+		if l.hist, err = newHDRHistogram(l.Precision); err != nil {
+			l.hist, _ = newHDRHistogram(DefaultPrecision)
+		}
+	}
+	return l.hist
+}
+
+// Add adds the given latency to the latency metrics.
+func (l *LatencyMetrics) Add(latency time.Duration) {
+	if l.Total += latency; latency > l.Max {
+		l.Max = latency
+	}
+	if latency < l.Min || l.Min == 0 {
+		l.Min = latency
+	}
+	l.histogram().Record(int64(latency), 1)
+}
+
+// Quantile returns the nth quantile from the latency summary, to the
+// precision of the LatencyMetrics.
+func (l *LatencyMetrics) Quantile(nth float64) time.Duration {
+	h := l.histogram()
+	if h.total == 0 {
+		return 0
+	}
+
+	switch {
+	case nth <= 0:
+		return l.Min
+	case nth >= 1:
+		return l.Max
+	}
+
+	// The highest value of a sub-bucket may overshoot the latencies which
+	// were actually recorded.
+	q := time.Duration(h.Quantile(nth))
+	return min(max(q, l.Min), l.Max)
+}
+
+// summarize computes the mean and quantiles of the given number of
+// latencies.
+func (l *LatencyMetrics) summarize(n uint64) {
+	l.Mean = time.Duration(float64(l.Total) / float64(n))
+	l.P50 = l.Quantile(0.50)
+	l.P90 = l.Quantile(0.90)
+	l.P95 = l.Quantile(0.95)
+	l.P99 = l.Quantile(0.99)
+}
+
+// Merge adds the latencies of the given LatencyMetrics.
+func (l *LatencyMetrics) Merge(o *LatencyMetrics) {
+	l.Total += o.Total
+	if o.Max > l.Max {
+		l.Max = o.Max
+	}
+	if o.Min > 0 && (o.Min < l.Min || l.Min == 0) {
+		l.Min = o.Min
+	}
+	if o.hist != nil {
+		l.histogram().Merge(o.hist)
+	}
+}
+
+// ByteMetrics holds computed byte flow metrics.
+type ByteMetrics struct {
+	// Total is the total number of flowing bytes in an attack.
+	Total uint64 `json:"total"`
+	// Mean is the mean number of flowing bytes per hit.
+	Mean float64 `json:"mean"`
+}
@@ -0,0 +1,108 @@
+package gogeta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetricsMergeEncoded(t *testing.T) {
+	t.Parallel()
+
+	var (
+		all   Metrics
+		parts [2]Metrics
+		began = time.Unix(0, 0)
+		rng   = rand.New(rand.NewSource(1))
+	)
+
+	for i := 0; i < 2000; i++ {
+		r := &Result{
+			Plan:      []string{"a", "b"}[i%2],
+			Code:      200,
+			Timestamp: began.Add(time.Duration(i) * time.Millisecond),
+			Latency:   time.Duration(rng.ExpFloat64() * float64(20*time.Millisecond)),
+			BytesIn:   10,
+		}
+		if i%7 == 0 {
+			r.Code, r.Error = 500, fmt.Sprintf("500 Internal Server Error %d", i%3)
+		}
+		all.Add(r)
+		parts[i/1000].Add(r)
+	}
+
+	var merged Metrics
+	for i := range parts {
+		parts[i].Close()
+
+		var buf bytes.Buffer
+		if err := NewMergeableJSONReporter(&parts[i])(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded Metrics
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		merged.Merge(&decoded)
+	}
+
+	all.Close()
+	merged.Close()
+
+	if got, want := merged.Latencies, all.Latencies; got.Total != want.Total || got.Min != want.Min || got.Max != want.Max ||
+		got.P50 != want.P50 || got.P90 != want.P90 || got.P95 != want.P95 || got.P99 != want.P99 {
+		t.Errorf("latencies: got %+v, want %+v", got, want)
+	}
+	if got, want := merged.Plans[1].Latencies.P99, all.Plans[1].Latencies.P99; got != want {
+		t.Errorf("p99 of plan b: got %s, want %s", got, want)
+	}
+	if merged.Requests != all.Requests || merged.Successes != all.Successes || merged.Success != all.Success || merged.Throughput != all.Throughput {
+		t.Errorf("requests, successes, success, throughput: got %d, %d, %g, %g, want %d, %d, %g, %g",
+			merged.Requests, merged.Successes, merged.Success, merged.Throughput, all.Requests, all.Successes, all.Success, all.Throughput)
+	}
+	if got, want := merged.ErrorCount("error 1"), all.ErrorCount("error 1"); got != want {
+		t.Errorf("error count: got %d, want %d", got, want)
+	}
+	if !reflect.DeepEqual(merged.Errors, all.Errors) || !reflect.DeepEqual(merged.StatusCodes, all.StatusCodes) {
+		t.Errorf("errors, status codes: got %v, %v, want %v, %v", merged.Errors, merged.StatusCodes, all.Errors, all.StatusCodes)
+	}
+}
+
+func TestJSONReporterLeavesOutHistograms(t *testing.T) {
+	t.Parallel()
+
+	var m Metrics
+	for i := 0; i < 100; i++ {
+		m.Add(&Result{Plan: "a", Code: 200, Timestamp: time.Unix(int64(i), 0), Latency: time.Duration(i+1) * time.Millisecond})
+	}
+	m.Close()
+
+	var plain, mergeable bytes.Buffer
+	if err := NewJSONReporter(&m)(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewMergeableJSONReporter(&m)(&mergeable); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(plain.Bytes(), []byte(`"histogram"`)) {
+		t.Errorf("the JSON report has histograms: %s", plain.Bytes())
+	}
+	// The top level latencies and response times, and those of the plan.
+	if got, want := bytes.Count(mergeable.Bytes(), []byte(`"histogram"`)), 4; got != want {
+		t.Errorf("the mergeable JSON report has %d histograms, want %d", got, want)
+	}
+	// Reporting a mergeable report doesn't make the next plain one mergeable.
+	plain.Reset()
+	if err := NewJSONReporter(&m)(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(plain.Bytes(), []byte(`"histogram"`)) {
+		t.Errorf("the JSON report after a mergeable one has histograms")
+	}
+}
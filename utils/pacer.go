@@ -2,14 +2,20 @@ package gogeta
 
 import (
 	"fmt"
+	"gopkg.in/yaml.v3"
 	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Pacer interface {
 	// Pace returns the duration an Attacker should wait until
 	// hitting the next Target, given an already elapsed duration and
-	// completed hits. If the second return value is true, an attacker
+	// completed hits. It's negative when the next hit is already late,
+	// by how late it is. If the second return value is true, an attacker
 	// should stop sending hits.
 	Pace(elapsed time.Duration, hits uint64) (wait time.Duration, stop bool)
 
@@ -38,18 +44,14 @@ func (c ConstantPacer) Pace(elapsed time.Duration, hits uint64) (wait time.Durat
 		return 0, true
 	}
 
-	expectedHits := uint64(c.Freq) * uint64(elapsed/c.Per)
-	if hits < expectedHits {
-		// Running behind, send next hit immediately.
-		return 0, false
-	}
 	interval := uint64(c.Per.Nanoseconds() / int64(c.Freq))
 	if math.MaxInt64/interval < hits {
 		// We would overflow delta if we continued, so stop the attack.
 		return 0, true
 	}
 	delta := time.Duration((hits + 1) * interval)
-	// Zero or negative durations cause time.Sleep to return immediately.
+	// Zero or negative durations cause time.Sleep to return immediately, so
+	// the next hit is sent right away when running behind.
 	return delta - elapsed, false
 }
 
@@ -61,8 +63,491 @@ func (c ConstantPacer) hitsPerNs() float64 {
 	return float64(c.Freq) / float64(c.Per)
 }
 
+// hitsPerSec returns the rate of the ConstantPacer in hits per second, zero
+// for the infinite and negative ones.
+func (c ConstantPacer) hitsPerSec() float64 {
+	if c.Per <= 0 || c.Freq <= 0 {
+		return 0
+	}
+	return c.hitsPerNs() * 1e9
+}
+
 // Rate is a type alias for ConstantPacer for backwards-compatibility.
 type Rate = ConstantPacer
 
 // ConstantPacer satisfies the Pacer interface.
 var _ Pacer = ConstantPacer{}
+
+// ParseRate parses a rate in the "freq/duration" format, e.g. 50/1s or 50/s.
+// A bare frequency is per second, while "infinity" and a zero frequency
+// yield the zero Rate, meaning no limit.
+func ParseRate(v string) (Rate, error) {
+	var r Rate
+	if v == "infinity" {
+		return r, nil
+	}
+
+	ps := strings.SplitN(v, "/", 2)
+	if len(ps) == 1 {
+		ps = append(ps, "1s")
+	}
+
+	var err error
+	if r.Freq, err = strconv.Atoi(ps[0]); err != nil {
+		return r, fmt.Errorf("rate %q doesn't match the \"freq/duration\" format (i.e. 50/1s)", v)
+	}
+
+	if r.Freq == 0 {
+		return r, nil
+	}
+
+	switch ps[1] {
+	case "ns", "us", "µs", "ms", "s", "m", "h":
+		ps[1] = "1" + ps[1]
+	}
+
+	r.Per, err = time.ParseDuration(ps[1])
+	return r, err
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, reading a Rate
+// in the format of ParseRate.
+func (c *ConstantPacer) UnmarshalYAML(node *yaml.Node) (err error) {
+	*c, err = ParseRate(node.Value)
+	return err
+}
+
+// LinearPacer paces an attack by starting at a given request rate
+// and increasing linearly with the given slope.
+type LinearPacer struct {
+	StartAt Rate
+	Slope   float64
+}
+
+// String returns a pretty-printed description of the LinearPacer's behaviour:
+//
+//	LinearPacer{Slope: 1} => Linear{1 + 1x}
+func (p LinearPacer) String() string {
+	return fmt.Sprintf("Linear{%g + %gx}", p.StartAt.hitsPerSec(), p.Slope)
+}
+
+// Pace determines the length of time to sleep until the next hit is sent.
+func (p LinearPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	switch {
+	case p.StartAt.Per < 0 || p.StartAt.Freq < 0:
+		return 0, true
+	case p.Slope == 0 && (p.StartAt.Per == 0 || p.StartAt.Freq == 0):
+		return 0, false // Zero value = infinite rate
+	}
+
+	secs, ok := rampTime(p.StartAt.hitsPerSec(), p.Slope, float64(hits+1))
+	if !ok {
+		// The rate dropped to zero before the next hit was due.
+		return 0, true
+	}
+	return seconds(secs) - elapsed, false
+}
+
+// Rate returns a LinearPacer's instantaneous hit rate (per seconds)
+// at the given elapsed duration of an attack.
+func (p LinearPacer) Rate(elapsed time.Duration) float64 {
+	return math.Max(0, p.StartAt.hitsPerSec()+p.Slope*elapsed.Seconds())
+}
+
+// StepPacer paces an attack by starting at a given request rate and
+// changing it by Step hits per second every Every duration.
+type StepPacer struct {
+	StartAt Rate
+	Step    float64
+	Every   time.Duration
+}
+
+// String returns a pretty-printed description of the StepPacer's behaviour:
+//
+//	StepPacer{StartAt: Rate{10, time.Second}, Step: 5, Every: time.Minute} => Step{10 + 5/1m0s}
+func (p StepPacer) String() string {
+	return fmt.Sprintf("Step{%g + %g/%s}", p.StartAt.hitsPerSec(), p.Step, p.Every)
+}
+
+// Pace determines the length of time to sleep until the next hit is sent.
+func (p StepPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	switch {
+	case p.StartAt.Per < 0 || p.StartAt.Freq < 0 || p.Every < 0:
+		return 0, true
+	case p.Step == 0 || p.Every == 0:
+		return ConstantPacer(p.StartAt).Pace(elapsed, hits)
+	}
+
+	var (
+		n    = float64(hits + 1)
+		b    = p.StartAt.hitsPerSec()
+		step = p.Every.Seconds()
+	)
+
+	// total returns the hits sent during the first k whole steps.
+	total := func(k float64) float64 { return step * (k*b + p.Step*k*(k-1)/2) }
+
+	// Treating the number of steps as continuous, the hits sent grow as
+	// a quadratic, so the step the next hit falls in can be solved for.
+	kc, ok := rampTime(b-p.Step/2, p.Step, n/step)
+	if !ok {
+		return 0, true
+	}
+
+	// Settle rounding errors so that total(k) < n <= total(k+1). A
+	// decreasing rate may reach zero first, past which no hit is ever due.
+	k := math.Floor(kc)
+	for k > 0 && total(k) >= n {
+		k--
+	}
+	for total(k+1) < n {
+		if k++; b+k*p.Step <= 0 {
+			return 0, true
+		}
+	}
+
+	rate := b + k*p.Step
+	if rate <= 0 {
+		return 0, true
+	}
+
+	return seconds(k*step+(n-total(k))/rate) - elapsed, false
+}
+
+// Rate returns a StepPacer's instantaneous hit rate (per seconds)
+// at the given elapsed duration of an attack.
+func (p StepPacer) Rate(elapsed time.Duration) float64 {
+	if p.Every <= 0 {
+		return p.StartAt.hitsPerSec()
+	}
+	steps := float64(elapsed / p.Every)
+	return math.Max(0, p.StartAt.hitsPerSec()+steps*p.Step)
+}
+
+// A Stage of a StagesPacer during which the rate changes linearly to reach
+// Target hits per second after Duration.
+type Stage struct {
+	Duration time.Duration `yaml:"duration"`
+	Target   float64       `yaml:"target"`
+}
+
+// ParseStages parses a comma separated list of stages in the
+// "duration:target" format, e.g. 30s:100,1m:500,30s:0.
+func ParseStages(v string) ([]Stage, error) {
+	var stages []Stage
+	for _, s := range strings.Split(v, ",") {
+		var st Stage
+		if err := st.parse(s); err != nil {
+			return nil, err
+		}
+		stages = append(stages, st)
+	}
+	return stages, nil
+}
+
+func (st *Stage) parse(s string) (err error) {
+	ps := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(ps) != 2 {
+		return fmt.Errorf("stage %q doesn't match the \"duration:target\" format (i.e. 30s:100)", s)
+	}
+
+	if st.Duration, err = time.ParseDuration(ps[0]); err != nil {
+		return err
+	}
+
+	st.Target, err = strconv.ParseFloat(ps[1], 64)
+	return err
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, accepting the
+// "duration:target" shorthand besides a mapping.
+func (st *Stage) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return st.parse(node.Value)
+	}
+	type plain Stage
+	return node.Decode((*plain)(st))
+}
+
+// StagesPacer paces an attack through a list of Stages, interpolating
+// linearly between the rate reached at the end of the previous stage, or
+// StartAt for the first one, and each stage's Target. A zero Duration stage
+// jumps to its Target. The attack stops once the last stage is over.
+type StagesPacer struct {
+	StartAt float64
+	Stages  []Stage
+}
+
+// NewStagesPacer returns a StagesPacer through the given Stages, ramping up
+// from the given rate, or from zero if it's nil.
+func NewStagesPacer(start *Rate, stages []Stage) StagesPacer {
+	p := StagesPacer{Stages: stages}
+	if start != nil {
+		p.StartAt = start.Rate(0)
+	}
+	return p
+}
+
+// String returns a pretty-printed description of the StagesPacer's behaviour:
+//
+//	StagesPacer{Stages: []Stage{{30 * time.Second, 100}, {time.Minute, 0}}} => Stages{0 -> 100/30s -> 0/1m0s}
+func (p StagesPacer) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Stages{%g", p.StartAt)
+	for _, st := range p.Stages {
+		fmt.Fprintf(&b, " -> %g/%s", st.Target, st.Duration)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// Pace determines the length of time to sleep until the next hit is sent.
+func (p StagesPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	var (
+		n     = float64(hits + 1)
+		start = p.StartAt
+		begin float64
+	)
+
+	for _, st := range p.Stages {
+		if st.Duration < 0 || st.Target < 0 {
+			return 0, true
+		}
+
+		dur := st.Duration.Seconds()
+		if dur == 0 {
+			start = st.Target
+			continue
+		}
+
+		if hits := dur * (start + st.Target) / 2; n <= hits {
+			secs, ok := rampTime(start, (st.Target-start)/dur, n)
+			if !ok || secs > dur {
+				// Only reachable through rounding errors at the very
+				// end of the stage.
+				secs = dur
+			}
+			return seconds(begin+secs) - elapsed, false
+		} else {
+			// The next hit isn't due within this stage.
+			n -= hits
+		}
+
+		begin += dur
+		start = st.Target
+	}
+
+	return 0, true
+}
+
+// Rate returns a StagesPacer's instantaneous hit rate (per seconds)
+// at the given elapsed duration of an attack.
+func (p StagesPacer) Rate(elapsed time.Duration) float64 {
+	var (
+		t     = elapsed.Seconds()
+		start = p.StartAt
+	)
+
+	for _, st := range p.Stages {
+		dur := st.Duration.Seconds()
+		if t < dur {
+			return start + (st.Target-start)*t/dur
+		}
+		t -= dur
+		start = st.Target
+	}
+
+	return 0
+}
+
+// SinePacer is a Pacer that describes attack request rates with the equation:
+//
+//	R = M + A sin(O+(2𝛑/P)t)
+//
+// Where:
+//
+//	R = Instantaneous attack rate at elapsed time t, hits per second
+//	M = Mean attack rate over period P, sp.Mean, hits per second
+//	A = Amplitude of sine wave, sp.Amp, hits per second
+//	O = Offset of sine wave, sp.StartAt, radians
+//	P = Period of sine wave, sp.Period, seconds
+//	t = Elapsed time since attack start, seconds
+//
+// The Amplitude can't exceed the Mean, so the rate never turns negative.
+type SinePacer struct {
+	// The period of the sine wave, e.g. 20*time.Minute
+	// MUST BE > 0
+	Period time.Duration
+	// The mid-point of the sine wave in freq-per-Duration,
+	// MUST BE > 0
+	Mean Rate
+	// The deviation from the mean.
+	// MUST BE <= Mean
+	Amp Rate
+	// The offset, in radians, for the sine wave at t=0.
+	StartAt float64
+}
+
+// String returns a pretty-printed description of the SinePacer's behaviour:
+//
+//	SinePacer{Period: time.Hour, Mean: Rate{100, time.Second}, Amp: Rate{50, time.Second}, StartAt: 0} =>
+//	Sine{Constant{100 hits/1s} ± Constant{50 hits/1s} / 1h0m0s, offset 0 rad}
+func (sp SinePacer) String() string {
+	return fmt.Sprintf("Sine{%s ± %s / %s, offset %g rad}", sp.Mean, sp.Amp, sp.Period, sp.StartAt)
+}
+
+// Pace determines the length of time to sleep until the next hit is sent.
+func (sp SinePacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	switch {
+	case sp.Mean.Per == 0 || sp.Mean.Freq == 0:
+		return 0, false // Zero value = infinite rate
+	case sp.Mean.Per < 0 || sp.Mean.Freq < 0 || sp.Period <= 0:
+		return 0, true
+	}
+
+	m, a := sp.Mean.Rate(0), sp.Amp.hitsPerSec()
+	if a > m {
+		return 0, true
+	}
+
+	// The number of hits sent by time t is never further than aP/π from mt,
+	// which brackets the time the next hit is due. The hits function is
+	// monotonic since the rate is never negative, so bisection finds it.
+	var (
+		n      = float64(hits + 1)
+		spread = a * sp.Period.Seconds() / math.Pi
+		lo     = math.Max(0, (n-spread)/m)
+		hi     = (n + spread) / m
+	)
+
+	for i := 0; i < 100 && hi-lo > 1e-10; i++ {
+		mid := (lo + hi) / 2
+		if sp.hits(mid) < n {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return seconds(hi) - elapsed, false
+}
+
+// Rate returns a SinePacer's instantaneous hit rate (per seconds)
+// at the given elapsed duration of an attack.
+func (sp SinePacer) Rate(elapsed time.Duration) float64 {
+	return sp.Mean.Rate(0) + sp.Amp.hitsPerSec()*math.Sin(sp.StartAt+sp.radians(elapsed.Seconds()))
+}
+
+// hits returns the number of hits sent after t seconds, the integral of the
+// rate from zero to t.
+func (sp SinePacer) hits(t float64) float64 {
+	p := sp.Period.Seconds()
+	return sp.Mean.Rate(0)*t +
+		sp.Amp.hitsPerSec()*p/(2*math.Pi)*(math.Cos(sp.StartAt)-math.Cos(sp.StartAt+sp.radians(t)))
+}
+
+// radians converts seconds elapsed into a phase of the sine wave.
+func (sp SinePacer) radians(t float64) float64 {
+	return 2 * math.Pi * t / sp.Period.Seconds()
+}
+
+// PoissonPacer paces an attack as a Poisson process: the time between hits
+// is exponentially distributed around the Mean rate, like requests of many
+// independent users would be. Arrivals are drawn from an RNG seeded with
+// Seed, so two attacks with the same seed send hits at the same offsets.
+type PoissonPacer struct {
+	Mean Rate
+	Seed int64
+
+	mu   sync.Mutex
+	rng  *rand.Rand
+	hits uint64
+	at   float64
+}
+
+// NewPoissonPacer returns a PoissonPacer averaging the given rate.
+func NewPoissonPacer(mean Rate, seed int64) *PoissonPacer {
+	return &PoissonPacer{Mean: mean, Seed: seed}
+}
+
+// String returns a pretty-printed description of the PoissonPacer's behaviour:
+//
+//	NewPoissonPacer(Rate{10, time.Second}, 1) => Poisson{Constant{10 hits/1s}, seed 1}
+func (p *PoissonPacer) String() string {
+	return fmt.Sprintf("Poisson{%s, seed %d}", p.Mean, p.Seed)
+}
+
+// Pace determines the length of time to sleep until the next hit is sent.
+func (p *PoissonPacer) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	switch {
+	case p.Mean.Per == 0 || p.Mean.Freq == 0:
+		return 0, false // Zero value = infinite rate
+	case p.Mean.Per < 0 || p.Mean.Freq < 0:
+		return 0, true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rng == nil {
+		p.rng = rand.New(rand.NewSource(p.Seed))
+	}
+
+	// Arrival times are drawn in order, so the schedule only depends on
+	// the seed and not on when Pace gets called.
+	rate := p.Mean.Rate(0)
+	for p.hits < hits+1 {
+		p.at += p.rng.ExpFloat64() / rate
+		p.hits++
+	}
+
+	return seconds(p.at) - elapsed, false
+}
+
+// Rate returns a PoissonPacer's expected hit rate (per seconds), which
+// is constant throughout an attack.
+func (p *PoissonPacer) Rate(elapsed time.Duration) float64 {
+	return p.Mean.Rate(elapsed)
+}
+
+// rampTime returns the number of seconds it takes a rate starting at r0 hits
+// per second and changing by slope hits per second every second to add up
+// to n hits. It returns false if the rate reaches zero before that.
+func rampTime(r0, slope, n float64) (float64, bool) {
+	if slope == 0 {
+		if r0 <= 0 {
+			return 0, false
+		}
+		return n / r0, true
+	}
+
+	// Solves slope/2*t² + r0*t = n for the earliest positive t. This form of
+	// the quadratic formula avoids cancellation when slope is tiny.
+	disc := r0*r0 + 2*slope*n
+	if disc < 0 {
+		return 0, false
+	}
+
+	den := r0 + math.Sqrt(disc)
+	if den <= 0 {
+		return 0, false
+	}
+	return 2 * n / den, true
+}
+
+// seconds converts a float number of seconds into a time.Duration.
+func seconds(s float64) time.Duration {
+	if s >= math.MaxInt64/1e9 {
+		return math.MaxInt64
+	}
+	return time.Duration(math.Round(s * 1e9))
+}
+
+// The following pacers satisfy the Pacer interface.
+var (
+	_ Pacer = LinearPacer{}
+	_ Pacer = StepPacer{}
+	_ Pacer = StagesPacer{}
+	_ Pacer = SinePacer{}
+	_ Pacer = &PoissonPacer{}
+)
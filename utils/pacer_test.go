@@ -0,0 +1,52 @@
+package gogeta
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStepPacerDecreasing(t *testing.T) {
+	t.Parallel()
+
+	// Rates of 10, 5 and then 0 hits/s for 1.5s each send 22.5 hits.
+	p := StepPacer{StartAt: Rate{Freq: 10, Per: time.Second}, Step: -5, Every: 1500 * time.Millisecond}
+
+	done := make(chan uint64)
+	go func() {
+		var hits uint64
+		for ; hits < 100; hits++ {
+			if _, stop := p.Pace(0, hits); stop {
+				break
+			}
+		}
+		done <- hits
+	}()
+
+	select {
+	case hits := <-done:
+		if hits != 22 {
+			t.Errorf("stopped after %d hits, want 22", hits)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pace didn't return")
+	}
+}
+
+func TestLinearPacerWithoutSlopeMatchesConstant(t *testing.T) {
+	t.Parallel()
+
+	// A linear pacer without a slope paces hits like a constant one, also
+	// when the attack is running behind.
+	rate := Rate{Freq: 10, Per: time.Second}
+	constant, linear := rate, LinearPacer{StartAt: rate}
+
+	for _, elapsed := range []time.Duration{0, 250 * time.Millisecond, 3 * time.Second} {
+		for hits := uint64(0); hits < 50; hits++ {
+			cw, cs := constant.Pace(elapsed, hits)
+			lw, ls := linear.Pace(elapsed, hits)
+			if cw != lw || cs != ls {
+				t.Errorf("Pace(%s, %d): constant %s, %t, linear %s, %t", elapsed, hits, cw, cs, lw, ls)
+			}
+		}
+	}
+}
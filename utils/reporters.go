@@ -0,0 +1,263 @@
+package gogeta
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A Report represents the state a Reporter uses to write out its reports.
+type Report interface {
+	// Add adds a given *Result to a Report.
+	Add(*Result)
+}
+
+// Closer wraps the optional Report Close method.
+type Closer interface {
+	// Close permantently closes a Report, running any necessary book keeping.
+	Close()
+}
+
+// A Reporter function writes out reports to the given io.Writer or returns an
+// error in case of failure.
+type Reporter func(io.Writer) error
+
+// Report is a convenience method wrapping the Reporter function type.
+func (rep Reporter) Report(w io.Writer) error { return rep(w) }
+
+// NewHistogramReporter returns a Reporter that writes out a Histogram as
+// aligned, formatted text.
+func NewHistogramReporter(h *Histogram) Reporter {
+	return func(w io.Writer) (err error) {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.StripEscape)
+		if _, err = fmt.Fprintf(tw, "Bucket\t\t#\t%%\tHistogram\n"); err != nil {
+			return err
+		}
+
+		for i, count := range h.Counts {
+			var ratio float64
+			if h.Total > 0 {
+				ratio = float64(count) / float64(h.Total)
+			}
+			lo, hi := h.Buckets.Nth(i)
+			pad := strings.Repeat("#", int(ratio*75))
+			_, err = fmt.Fprintf(tw, "[%s,\t%s]\t%d\t%.2f%%\t%s\n", lo, hi, count, ratio*100, pad)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tw.Flush()
+	}
+}
+
+// NewTextReporter returns a Reporter that writes out Metrics as aligned,
+// formatted text.
+func NewTextReporter(m *Metrics) Reporter {
+	const fmtstr = "Requests\t[total, rate, throughput]\t%d, %.2f, %.2f\n" +
+		"Duration\t[total, attack, wait]\t%s, %s, %s\n" +
+		"Latencies\t[min, mean, 50, 90, 95, 99, max]\t%s, %s, %s, %s, %s, %s, %s\n" +
+		"%s" +
+		"Bytes In\t[total, mean]\t%d, %.2f\n" +
+		"Bytes Out\t[total, mean]\t%d, %.2f\n" +
+		"Success\t[ratio]\t%.2f%%\n" +
+		"Workers\t[max]\t%d\n" +
+		"Status Codes\t[code:count]\t"
+
+	return func(w io.Writer) (err error) {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.StripEscape)
+		if _, err = fmt.Fprintf(tw, fmtstr,
+			m.Requests, m.Rate, m.Throughput,
+			round(m.Duration+m.Wait),
+			round(m.Duration),
+			round(m.Wait),
+			round(m.Latencies.Min),
+			round(m.Latencies.Mean),
+			round(m.Latencies.P50),
+			round(m.Latencies.P90),
+			round(m.Latencies.P95),
+			round(m.Latencies.P99),
+			round(m.Latencies.Max),
+			responseTimes(m),
+			m.BytesIn.Total, m.BytesIn.Mean,
+			m.BytesOut.Total, m.BytesOut.Mean,
+			m.Success*100,
+			m.Workers,
+		); err != nil {
+			return err
+		}
+
+		codes := make([]string, 0, len(m.StatusCodes))
+		for code := range m.StatusCodes {
+			codes = append(codes, code)
+		}
+
+		sort.Strings(codes)
+
+		for _, code := range codes {
+			count := m.StatusCodes[code]
+			if _, err = fmt.Fprintf(tw, "%s:%d  ", code, count); err != nil {
+				return err
+			}
+		}
+
+		if _, err = fmt.Fprintln(tw); err != nil {
+			return err
+		}
+
+		// A single plan's breakdown would only repeat the totals above.
+		if len(m.Plans) > 1 {
+			// Flushing ends the column block above, so the plan table gets
+			// aligned on its own.
+			if err = tw.Flush(); err != nil {
+				return err
+			}
+			if _, err = fmt.Fprintln(tw, "Plans\t[requests, share, success, mean, 50, 95, 99, max]"); err != nil {
+				return err
+			}
+
+			for _, pm := range m.Plans {
+				if _, err = fmt.Fprintf(tw, "  %s\t%d, %.2f%%, %.2f%%, %s, %s, %s, %s, %s\n",
+					pm.Plan, pm.Requests, pm.Share*100, pm.Success*100,
+					round(pm.Latencies.Mean),
+					round(pm.Latencies.P50),
+					round(pm.Latencies.P95),
+					round(pm.Latencies.P99),
+					round(pm.Latencies.Max),
+				); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(m.Steps) > 0 {
+			// Flushing ends the column block above, so the step table gets
+			// aligned on its own.
+			if err = tw.Flush(); err != nil {
+				return err
+			}
+			if _, err = fmt.Fprintln(tw, "Steps\t[requests, success, mean, 50, 95, 99, max]"); err != nil {
+				return err
+			}
+		}
+
+		for _, sm := range m.Steps {
+			name := fmt.Sprintf("%s#%d", sm.Plan, sm.Step)
+			if sm.Name != "" {
+				name += " " + sm.Name
+			}
+			if _, err = fmt.Fprintf(tw, "  %s\t%d, %.2f%%, %s, %s, %s, %s, %s\n",
+				name, sm.Requests, sm.Success*100,
+				round(sm.Latencies.Mean),
+				round(sm.Latencies.P50),
+				round(sm.Latencies.P95),
+				round(sm.Latencies.P99),
+				round(sm.Latencies.Max),
+			); err != nil {
+				return err
+			}
+		}
+
+		if len(m.Checks) > 0 {
+			if err = tw.Flush(); err != nil {
+				return err
+			}
+			if _, err = fmt.Fprintln(tw, "Checks\t[passes, fails, ratio]"); err != nil {
+				return err
+			}
+		}
+
+		for _, cm := range m.Checks {
+			step := cm.Plan
+			if cm.Step > 0 {
+				step = fmt.Sprintf("%s#%d", cm.Plan, cm.Step)
+			}
+			if cm.StepName != "" {
+				step += " " + cm.StepName
+			}
+			name := cm.Name
+			if step != "" {
+				name = step + ": " + name
+			}
+			if _, err = fmt.Fprintf(tw, "  %s\t%d, %d, %.2f%%\n",
+				name, cm.Passes, cm.Fails, cm.Ratio*100,
+			); err != nil {
+				return err
+			}
+		}
+
+		if m.SchemaFailures > 0 {
+			if _, err = fmt.Fprintf(tw, "Schema Failures: %d\n", m.SchemaFailures); err != nil {
+				return err
+			}
+			for _, e := range m.SchemaErrors {
+				if _, err = fmt.Fprintln(tw, e); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err = fmt.Fprintln(tw, "Error Set:"); err != nil {
+			return err
+		}
+
+		for _, e := range m.Errors {
+			if _, err = fmt.Fprintln(tw, e); err != nil {
+				return err
+			}
+		}
+
+		if m.OtherErrors > 0 {
+			if _, err = fmt.Fprintf(tw, "... and %d more failed requests\n", m.OtherErrors); err != nil {
+				return err
+			}
+		}
+
+		return tw.Flush()
+	}
+}
+
+// NewJSONReporter returns a Reporter that writes out Metrics as JSON.
+func NewJSONReporter(m *Metrics) Reporter {
+	return func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(m)
+	}
+}
+
+// NewMergeableJSONReporter returns a Reporter that writes out Metrics as JSON
+// along with the HDR histograms of their latencies, so that the decoded
+// Metrics can be merged, e.g. across the nodes of a distributed attack. The
+// histograms make the report much larger than NewJSONReporter's.
+func NewMergeableJSONReporter(m *Metrics) Reporter {
+	return func(w io.Writer) error {
+		m.encodeHistograms(true)
+		defer m.encodeHistograms(false)
+		return json.NewEncoder(w).Encode(m)
+	}
+}
+
+// responseTimes returns the line of the text report with the response times
+// of the Metrics, measured from the intended start of the requests. It's
+// empty when they match the latencies because no request was delayed.
+func responseTimes(m *Metrics) string {
+	if !m.Delayed() {
+		return ""
+	}
+	rt := &m.ResponseTimes
+	return fmt.Sprintf("Response Times\t[min, mean, 50, 90, 95, 99, max]\t%s, %s, %s, %s, %s, %s, %s\n",
+		round(rt.Min), round(rt.Mean), round(rt.P50), round(rt.P90), round(rt.P95), round(rt.P99), round(rt.Max))
+}
+
+// round rounds the given duration to a precision which keeps reports readable.
+func round(d time.Duration) time.Duration {
+	for i := time.Duration(1); i < d; i *= 10 {
+		if d/i < 1000 {
+			return d.Round(i)
+		}
+	}
+	return d
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/base64"
 	"encoding/csv"
 	"encoding/gob"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/textproto"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -33,10 +36,50 @@ type Result struct {
 	Method    string        `json:"method"`
 	URL       string        `json:"url"`
 	Headers   http.Header   `json:"headers"`
+	// Plan is the name of the plan the hit Target belongs to.
+	Plan string `json:"plan,omitempty"`
+	// Step is the 1-based position of a request within a chained Target.
+	// It is zero for the Result covering a whole iteration, which shares
+	// its Seq with the Results of each of its steps and leaves their Body
+	// and Headers to them.
+	Step int `json:"step,omitempty"`
+	// StepName is the name of the step in the plan, if any.
+	StepName string `json:"step_name,omitempty"`
+	// Workers is the number of workers the attack had started when the
+	// hit began.
+	Workers uint64 `json:"workers,omitempty"`
+	// Iteration is the wall clock duration of a whole iteration, including
+	// the think time and pacing excluded from its Latency. It is only set
+	// on Results covering whole iterations.
+	Iteration time.Duration `json:"iteration,omitempty"`
+	// Checks holds the outcome of the checks of the step, in order.
+	Checks []CheckResult `json:"checks,omitempty"`
+	// SchemaErrors holds the first violations of the JSON Schema checks of
+	// the step by the response body.
+	SchemaErrors []string `json:"schema_errors,omitempty"`
+	// Intended is the time the pacer meant the iteration to start at, which
+	// Timestamp is later than when all the workers were busy. It is zero
+	// for steps and for attacks without a pacer.
+	Intended time.Time `json:"intended"`
+}
+
+// ResponseTime returns the latency of a Result counted from its intended
+// start, which includes the time it waited for a worker. It's the Latency
+// of Results without an intended start.
+func (r *Result) ResponseTime() time.Duration {
+	if r.Intended.IsZero() || r.Intended.After(r.Timestamp) {
+		return r.Latency
+	}
+	return r.Latency + r.Timestamp.Sub(r.Intended)
 }
 
 // End returns the time at which a Result ended.
-func (r *Result) End() time.Time { return r.Timestamp.Add(r.Latency) }
+func (r *Result) End() time.Time {
+	if r.Iteration > r.Latency {
+		return r.Timestamp.Add(r.Iteration)
+	}
+	return r.Timestamp.Add(r.Latency)
+}
 
 // Equal returns true if the given Result is equal to the receiver.
 func (r Result) Equal(other Result) bool {
@@ -51,7 +94,15 @@ func (r Result) Equal(other Result) bool {
 		bytes.Equal(r.Body, other.Body) &&
 		r.Method == other.Method &&
 		r.URL == other.URL &&
-		headerEqual(r.Headers, other.Headers)
+		headerEqual(r.Headers, other.Headers) &&
+		r.Plan == other.Plan &&
+		r.Step == other.Step &&
+		r.StepName == other.StepName &&
+		r.Workers == other.Workers &&
+		r.Iteration == other.Iteration &&
+		slices.Equal(r.Checks, other.Checks) &&
+		slices.Equal(r.SchemaErrors, other.SchemaErrors) &&
+		r.Intended.Equal(other.Intended)
 }
 
 func headerEqual(h1, h2 http.Header) bool {
@@ -76,7 +127,9 @@ func headerEqual(h1, h2 http.Header) bool {
 	return true
 }
 
-// Results is a slice of Result type elements.
+// Results is a slice of Result type elements. It keeps every Result whole,
+// bodies and headers included, so long attacks are better aggregated in
+// Metrics.
 type Results []Result
 
 // Add implements the Add method of the Report interface by appending the given
@@ -105,6 +158,7 @@ func DecoderFor(r io.Reader) Decoder {
 	var buf bytes.Buffer
 	for _, dec := range []DecoderFactory{
 		NewDecoder,
+		NewJSONDecoder,
 		NewCSVDecoder,
 	} {
 		rd := io.MultiReader(bytes.NewReader(buf.Bytes()), io.TeeReader(r, &buf))
@@ -163,10 +217,33 @@ func (enc Encoder) Encode(r *Result) error { return enc(r) }
 // NewCSVEncoder returns an Encoder that dumps the given *Result as a CSV
 // record. The columns are: UNIX timestamp in ns since epoch,
 // HTTP status code, request latency in ns, bytes out, bytes in,
-// response body, and lastly the error.
+// the error, base64 encoded response body, attack name, sequence number,
+// method, URL, base64 encoded response headers, plan name, step number,
+// step name, number of workers, the iteration duration in ns, the JSON
+// encoded check results, the JSON encoded schema violations and lastly the
+// intended start as a UNIX timestamp in ns, empty if unknown.
 func NewCSVEncoder(w io.Writer) Encoder {
 	enc := csv.NewWriter(w)
 	return func(r *Result) error {
+		var checks, schemaErrors []byte
+		if len(r.Checks) > 0 {
+			var err error
+			if checks, err = json.Marshal(r.Checks); err != nil {
+				return err
+			}
+		}
+		if len(r.SchemaErrors) > 0 {
+			var err error
+			if schemaErrors, err = json.Marshal(r.SchemaErrors); err != nil {
+				return err
+			}
+		}
+
+		var intended string
+		if !r.Intended.IsZero() {
+			intended = strconv.FormatInt(r.Intended.UnixNano(), 10)
+		}
+
 		err := enc.Write([]string{
 			strconv.FormatInt(r.Timestamp.UnixNano(), 10),
 			strconv.FormatUint(uint64(r.Code), 10),
@@ -180,6 +257,14 @@ func NewCSVEncoder(w io.Writer) Encoder {
 			r.Method,
 			r.URL,
 			base64.StdEncoding.EncodeToString(headerBytes(r.Headers)),
+			r.Plan,
+			strconv.Itoa(r.Step),
+			r.StepName,
+			strconv.FormatUint(r.Workers, 10),
+			strconv.FormatInt(r.Iteration.Nanoseconds(), 10),
+			string(checks),
+			string(schemaErrors),
+			intended,
 		})
 		if err != nil {
 			return err
@@ -200,10 +285,19 @@ func headerBytes(h http.Header) []byte {
 	return append(hdr.Bytes(), '\r', '\n')
 }
 
+const (
+	// csvMinFields is the number of columns of the oldest CSV records.
+	csvMinFields = 12
+	// csvFields is the number of columns written by NewCSVEncoder.
+	csvFields = 20
+)
+
 // NewCSVDecoder returns a Decoder that decodes CSV encoded Results.
 func NewCSVDecoder(r io.Reader) Decoder {
 	dec := csv.NewReader(r)
-	dec.FieldsPerRecord = 12
+	// Columns have been appended over time, so records written by older
+	// versions are shorter.
+	dec.FieldsPerRecord = -1
 	dec.TrimLeadingSpace = true
 
 	return func(r *Result) error {
@@ -212,6 +306,11 @@ func NewCSVDecoder(r io.Reader) Decoder {
 			return err
 		}
 
+		if len(rec) < csvMinFields {
+			return fmt.Errorf("csv: wrong number of fields: %d", len(rec))
+		}
+		rec = append(rec, make([]string, csvFields-min(len(rec), csvFields))...)
+
 		ts, err := strconv.ParseInt(rec[0], 10, 64)
 		if err != nil {
 			return err
@@ -261,6 +360,62 @@ func NewCSVDecoder(r io.Reader) Decoder {
 			r.Headers = http.Header(hdr)
 		}
 
+		r.Plan = rec[12]
+		if rec[13] != "" {
+			if r.Step, err = strconv.Atoi(rec[13]); err != nil {
+				return err
+			}
+		}
+		r.StepName = rec[14]
+		if rec[15] != "" {
+			if r.Workers, err = strconv.ParseUint(rec[15], 10, 64); err != nil {
+				return err
+			}
+		}
+		if rec[16] != "" {
+			iteration, err := strconv.ParseInt(rec[16], 10, 64)
+			if err != nil {
+				return err
+			}
+			r.Iteration = time.Duration(iteration)
+		}
+		if rec[17] != "" {
+			if err = json.Unmarshal([]byte(rec[17]), &r.Checks); err != nil {
+				return err
+			}
+		}
+		if rec[18] != "" {
+			if err = json.Unmarshal([]byte(rec[18]), &r.SchemaErrors); err != nil {
+				return err
+			}
+		}
+		if rec[19] != "" {
+			intended, err := strconv.ParseInt(rec[19], 10, 64)
+			if err != nil {
+				return err
+			}
+			r.Intended = time.Unix(0, intended)
+		}
+
 		return err
 	}
 }
+
+// NewJSONEncoder returns an Encoder that dumps the given *Results as a JSON
+// object followed by a newline, producing a newline delimited JSON stream.
+func NewJSONEncoder(w io.Writer) Encoder {
+	enc := json.NewEncoder(w)
+	return func(r *Result) error { return enc.Encode(r) }
+}
+
+// NewJSONDecoder returns a Decoder that decodes newline delimited JSON
+// encoded Results.
+func NewJSONDecoder(r io.Reader) Decoder {
+	dec := json.NewDecoder(r)
+	return func(r *Result) error {
+		// Reset the Result so fields omitted in the stream don't leak
+		// from a previous decode.
+		*r = Result{}
+		return dec.Decode(r)
+	}
+}
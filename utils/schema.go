@@ -0,0 +1,628 @@
+package gogeta
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Schema is a JSON Schema which response bodies are validated against.
+// It supports the keywords of drafts 4 to 2020-12 which constrain values:
+//
+//	type, enum, const, $ref (local to the schema only)
+//	allOf, anyOf, oneOf, not
+//	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+//	minLength, maxLength, pattern
+//	items, prefixItems, minItems, maxItems, uniqueItems, contains
+//	properties, patternProperties, additionalProperties, required,
+//	minProperties, maxProperties
+//
+// Other keywords, such as format, are ignored.
+type Schema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// LoadSchema reads the JSON Schema in the given file.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+
+	s, err := ParseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("schema: %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ParseSchema parses the given JSON Schema.
+func ParseSchema(data []byte) (*Schema, error) {
+	root, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schema{root: root, patterns: map[string]*regexp.Regexp{}}
+	if err = s.compile(root, "#"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile checks the given part of the Schema, found at the given pointer,
+// can be used, and parses its patterns once and for all.
+func (s *Schema) compile(node any, at string) error {
+	switch node := node.(type) {
+	case bool:
+		return nil
+	case map[string]any:
+		for _, k := range sortedKeys(node) {
+			v := node[k]
+			switch k {
+			case "$ref":
+				ref, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("%s/$ref: expected a string", at)
+				}
+				if _, err := s.resolve(ref); err != nil {
+					return fmt.Errorf("%s/$ref: %w", at, err)
+				}
+
+			case "pattern":
+				p, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("%s/pattern: expected a string", at)
+				}
+				if err := s.pattern(p); err != nil {
+					return fmt.Errorf("%s/pattern: %w", at, err)
+				}
+
+			case "patternProperties":
+				props, ok := v.(map[string]any)
+				if !ok {
+					return fmt.Errorf("%s/%s: expected an object", at, k)
+				}
+				for p, sub := range props {
+					if err := s.pattern(p); err != nil {
+						return fmt.Errorf("%s/%s: %w", at, k, err)
+					}
+					if err := s.compile(sub, at+"/"+k+"/"+p); err != nil {
+						return err
+					}
+				}
+
+			case "properties", "$defs", "definitions":
+				props, ok := v.(map[string]any)
+				if !ok {
+					return fmt.Errorf("%s/%s: expected an object", at, k)
+				}
+				for name, sub := range props {
+					if err := s.compile(sub, at+"/"+k+"/"+name); err != nil {
+						return err
+					}
+				}
+
+			case "allOf", "anyOf", "oneOf", "prefixItems":
+				subs, ok := v.([]any)
+				if !ok {
+					return fmt.Errorf("%s/%s: expected an array", at, k)
+				}
+				for i, sub := range subs {
+					if err := s.compile(sub, fmt.Sprintf("%s/%s/%d", at, k, i)); err != nil {
+						return err
+					}
+				}
+
+			case "items":
+				// Drafts before 2020-12 allow an array of schemas here.
+				if subs, ok := v.([]any); ok {
+					for i, sub := range subs {
+						if err := s.compile(sub, fmt.Sprintf("%s/%s/%d", at, k, i)); err != nil {
+							return err
+						}
+					}
+					break
+				}
+				fallthrough
+
+			case "not", "additionalProperties", "additionalItems", "contains":
+				if err := s.compile(v, at+"/"+k); err != nil {
+					return err
+				}
+
+			case "type":
+				types, ok := v.([]any)
+				if !ok {
+					types = []any{v}
+				}
+				for _, t := range types {
+					if name, ok := t.(string); !ok || !jsonTypes[name] {
+						return fmt.Errorf("%s/type: unknown type %v", at, jsonString(t))
+					}
+				}
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: expected a schema object or boolean", at)
+	}
+}
+
+func (s *Schema) pattern(p string) error {
+	if _, ok := s.patterns[p]; ok {
+		return nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return err
+	}
+	s.patterns[p] = re
+	return nil
+}
+
+// resolve returns the part of the Schema a local $ref points to.
+func (s *Schema) resolve(ref string) (any, error) {
+	ptr, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, fmt.Errorf("%q: only references within the schema are supported", ref)
+	}
+
+	cur := s.root
+	if ptr == "" {
+		return cur, nil
+	}
+	for _, tok := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		switch node := cur.(type) {
+		case map[string]any:
+			if cur, ok = node[tok]; !ok {
+				return nil, fmt.Errorf("%q: no %q", ref, tok)
+			}
+		case []any:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("%q: no %q", ref, tok)
+			}
+			cur = node[i]
+		default:
+			return nil, fmt.Errorf("%q: no %q", ref, tok)
+		}
+	}
+	return cur, nil
+}
+
+// Validate validates the given decoded JSON document against the Schema,
+// returning up to max of its violations, or all of them if max is zero.
+func (s *Schema) Validate(doc any, max int) []string {
+	v := schemaValidator{Schema: s, max: max}
+	v.validate(s.root, doc, "$", 0)
+	return v.errs
+}
+
+// maxRefDepth bounds the $refs followed while validating a single value, so
+// that recursive schemas can't loop forever.
+const maxRefDepth = 64
+
+type schemaValidator struct {
+	*Schema
+	max  int
+	errs []string
+}
+
+func (v *schemaValidator) full() bool { return v.max > 0 && len(v.errs) >= v.max }
+
+func (v *schemaValidator) errorf(at, format string, args ...any) {
+	if !v.full() {
+		v.errs = append(v.errs, at+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// match reports whether the given string matches the given pattern. The
+// patterns of subschemas only reachable through a $ref into an unknown
+// keyword weren't compiled upfront.
+func (v *schemaValidator) match(pattern, s string) bool {
+	re, ok := v.patterns[pattern]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return false
+		}
+	}
+	return re.MatchString(s)
+}
+
+// valid reports whether the given value matches the given schema, without
+// recording its violations.
+func (v *schemaValidator) valid(schema, doc any, at string, depth int) bool {
+	sub := schemaValidator{Schema: v.Schema, max: 1}
+	sub.validate(schema, doc, at, depth)
+	return len(sub.errs) == 0
+}
+
+func (v *schemaValidator) validate(schema, doc any, at string, depth int) {
+	if v.full() {
+		return
+	}
+
+	sch, ok := schema.(map[string]any)
+	if !ok {
+		if schema == false {
+			v.errorf(at, "no value is allowed")
+		}
+		return
+	}
+
+	if ref, ok := sch["$ref"].(string); ok {
+		if depth >= maxRefDepth {
+			v.errorf(at, "too many nested $refs")
+			return
+		}
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.errorf(at, "%v", err)
+			return
+		}
+		v.validate(target, doc, at, depth+1)
+	}
+
+	if t, ok := sch["type"]; ok {
+		types, ok := t.([]any)
+		if !ok {
+			types = []any{t}
+		}
+		match := false
+		for _, t := range types {
+			if name, _ := t.(string); isJSONType(doc, name) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			v.errorf(at, "expected %s, got %s", jsonString(t), jsonType(doc))
+			return
+		}
+	}
+
+	if enum, ok := sch["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if equalJSON(doc, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.errorf(at, "isn't one of %s", jsonString(enum))
+		}
+	}
+
+	if c, ok := sch["const"]; ok && !equalJSON(doc, c) {
+		v.errorf(at, "isn't %s", jsonLiteral(c))
+	}
+
+	v.combinators(sch, doc, at, depth)
+
+	switch doc := doc.(type) {
+	case json.Number:
+		v.number(sch, doc, at)
+	case string:
+		v.string(sch, doc, at)
+	case []any:
+		v.array(sch, doc, at, depth)
+	case map[string]any:
+		v.object(sch, doc, at, depth)
+	}
+}
+
+func (v *schemaValidator) combinators(sch map[string]any, doc any, at string, depth int) {
+	if all, ok := sch["allOf"].([]any); ok {
+		for _, sub := range all {
+			v.validate(sub, doc, at, depth)
+		}
+	}
+
+	if anyOf, ok := sch["anyOf"].([]any); ok {
+		match := false
+		for _, sub := range anyOf {
+			if v.valid(sub, doc, at, depth) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			v.errorf(at, "doesn't match any schema of anyOf")
+		}
+	}
+
+	if one, ok := sch["oneOf"].([]any); ok {
+		matches := 0
+		for _, sub := range one {
+			if v.valid(sub, doc, at, depth) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.errorf(at, "matches %d schemas of oneOf instead of 1", matches)
+		}
+	}
+
+	if not, ok := sch["not"]; ok && v.valid(not, doc, at, depth) {
+		v.errorf(at, "matches the schema of not")
+	}
+}
+
+func (v *schemaValidator) number(sch map[string]any, n json.Number, at string) {
+	val, ok := new(big.Rat).SetString(n.String())
+	if !ok {
+		v.errorf(at, "invalid number %s", n)
+		return
+	}
+
+	bound := func(k string) (*big.Rat, bool) {
+		b, ok := sch[k].(json.Number)
+		if !ok {
+			return nil, false
+		}
+		return new(big.Rat).SetString(b.String())
+	}
+
+	if min, ok := bound("minimum"); ok && val.Cmp(min) < 0 {
+		v.errorf(at, "is less than the minimum %s", sch["minimum"])
+	}
+	if max, ok := bound("maximum"); ok && val.Cmp(max) > 0 {
+		v.errorf(at, "is greater than the maximum %s", sch["maximum"])
+	}
+	if min, ok := bound("exclusiveMinimum"); ok && val.Cmp(min) <= 0 {
+		v.errorf(at, "isn't greater than %s", sch["exclusiveMinimum"])
+	}
+	if max, ok := bound("exclusiveMaximum"); ok && val.Cmp(max) >= 0 {
+		v.errorf(at, "isn't less than %s", sch["exclusiveMaximum"])
+	}
+
+	// Draft 4 has boolean exclusive bounds which apply to minimum and
+	// maximum.
+	if sch["exclusiveMinimum"] == true {
+		if min, ok := bound("minimum"); ok && val.Cmp(min) == 0 {
+			v.errorf(at, "isn't greater than %s", sch["minimum"])
+		}
+	}
+	if sch["exclusiveMaximum"] == true {
+		if max, ok := bound("maximum"); ok && val.Cmp(max) == 0 {
+			v.errorf(at, "isn't less than %s", sch["maximum"])
+		}
+	}
+
+	if m, ok := bound("multipleOf"); ok && m.Sign() != 0 {
+		if !new(big.Rat).Quo(val, m).IsInt() {
+			v.errorf(at, "isn't a multiple of %s", sch["multipleOf"])
+		}
+	}
+}
+
+func (v *schemaValidator) string(sch map[string]any, s, at string) {
+	n := utf8.RuneCountInString(s)
+	if min, ok := schemaInt(sch, "minLength"); ok && n < min {
+		v.errorf(at, "is shorter than %d characters", min)
+	}
+	if max, ok := schemaInt(sch, "maxLength"); ok && n > max {
+		v.errorf(at, "is longer than %d characters", max)
+	}
+	if p, ok := sch["pattern"].(string); ok && !v.match(p, s) {
+		v.errorf(at, "doesn't match %s", p)
+	}
+}
+
+func (v *schemaValidator) array(sch map[string]any, arr []any, at string, depth int) {
+	if min, ok := schemaInt(sch, "minItems"); ok && len(arr) < min {
+		v.errorf(at, "has fewer than %d items", min)
+	}
+	if max, ok := schemaInt(sch, "maxItems"); ok && len(arr) > max {
+		v.errorf(at, "has more than %d items", max)
+	}
+
+	if sch["uniqueItems"] == true {
+	unique:
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if equalJSON(arr[i], arr[j]) {
+					v.errorf(at, "has duplicate items")
+					break unique
+				}
+			}
+		}
+	}
+
+	// Leading items are matched by prefixItems, or by an array of items
+	// before 2020-12, and the rest by items, or additionalItems before
+	// 2020-12.
+	prefix, ok := sch["prefixItems"].([]any)
+	rest, hasRest := sch["items"]
+	if !ok {
+		if prefix, ok = rest.([]any); ok {
+			rest, hasRest = sch["additionalItems"]
+		}
+	}
+
+	for i, item := range arr {
+		itemAt := fmt.Sprintf("%s[%d]", at, i)
+		switch {
+		case i < len(prefix):
+			v.validate(prefix[i], item, itemAt, depth)
+		case hasRest:
+			v.validate(rest, item, itemAt, depth)
+		}
+	}
+
+	if contains, ok := sch["contains"]; ok {
+		found := false
+		for i, item := range arr {
+			if v.valid(contains, item, fmt.Sprintf("%s[%d]", at, i), depth) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.errorf(at, "no item matches the schema of contains")
+		}
+	}
+}
+
+func (v *schemaValidator) object(sch map[string]any, obj map[string]any, at string, depth int) {
+	if min, ok := schemaInt(sch, "minProperties"); ok && len(obj) < min {
+		v.errorf(at, "has fewer than %d properties", min)
+	}
+	if max, ok := schemaInt(sch, "maxProperties"); ok && len(obj) > max {
+		v.errorf(at, "has more than %d properties", max)
+	}
+
+	if required, ok := sch["required"].([]any); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, ok := obj[name]; !ok {
+					v.errorf(at, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	props, _ := sch["properties"].(map[string]any)
+	patterns, _ := sch["patternProperties"].(map[string]any)
+	additional, hasAdditional := sch["additionalProperties"]
+
+	for _, k := range sortedKeys(obj) {
+		val, propAt := obj[k], jsonPathKey(at, k)
+
+		matched := false
+		if sub, ok := props[k]; ok {
+			matched = true
+			v.validate(sub, val, propAt, depth)
+		}
+		for p, sub := range patterns {
+			if v.match(p, k) {
+				matched = true
+				v.validate(sub, val, propAt, depth)
+			}
+		}
+
+		if !matched && hasAdditional {
+			if additional == false {
+				v.errorf(at, "property %q isn't allowed", k)
+				continue
+			}
+			v.validate(additional, val, propAt, depth)
+		}
+	}
+}
+
+var jsonTypes = map[string]bool{
+	"null": true, "boolean": true, "integer": true, "number": true,
+	"string": true, "array": true, "object": true,
+}
+
+// isJSONType reports whether the decoded JSON value is of the given schema
+// type.
+func isJSONType(v any, typ string) bool {
+	switch v := v.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case json.Number:
+		if typ == "integer" {
+			r, ok := new(big.Rat).SetString(v.String())
+			return ok && r.IsInt()
+		}
+		return typ == "number"
+	case string:
+		return typ == "string"
+	case []any:
+		return typ == "array"
+	case map[string]any:
+		return typ == "object"
+	}
+	return false
+}
+
+func jsonType(v any) string {
+	for _, t := range []string{"null", "boolean", "integer", "number", "string", "array", "object"} {
+		if isJSONType(v, t) {
+			return t
+		}
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// jsonLiteral renders a decoded JSON value in its JSON form, unlike
+// jsonString which leaves strings unquoted.
+func jsonLiteral(v any) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return jsonString(v)
+}
+
+// equalJSON reports whether two decoded JSON values are equal, comparing
+// numbers by value.
+func equalJSON(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okx := new(big.Rat).SetString(a.String())
+		y, oky := new(big.Rat).SetString(b.String())
+		return okx && oky && x.Cmp(y) == 0
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalJSON(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			if vb, ok := b[k]; !ok || !equalJSON(va, vb) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func schemaInt(sch map[string]any, k string) (int, bool) {
+	n, ok := sch[k].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return int(i), err == nil
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jsonPathKey returns the JSONPath of the given member of the object at the
+// given path.
+func jsonPathKey(at, k string) string {
+	if identifier.MatchString(k) {
+		return at + "." + k
+	}
+	return at + "[" + strconv.Quote(k) + "]"
+}
@@ -0,0 +1,138 @@
+package gogeta
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name   string
+		schema string
+		doc    string
+		max    int
+		want   []string
+	}{
+		{
+			name:   "type",
+			schema: `{"type": "integer"}`,
+			doc:    `1.5`,
+			want:   []string{`$: expected integer, got number`},
+		},
+		{
+			name:   "type list",
+			schema: `{"type": ["string", "null"]}`,
+			doc:    `null`,
+		},
+		{
+			name:   "ref",
+			schema: `{"$defs": {"id": {"type": "string", "minLength": 3}}, "properties": {"id": {"$ref": "#/$defs/id"}}}`,
+			doc:    `{"id": "ab"}`,
+			want:   []string{"$.id: is shorter than 3 characters"},
+		},
+		{
+			name:   "draft 4 ref",
+			schema: `{"definitions": {"n": {"maximum": 1}}, "items": {"$ref": "#/definitions/n"}}`,
+			doc:    `[1, 2]`,
+			want:   []string{"$[1]: is greater than the maximum 1"},
+		},
+		{
+			name:   "oneOf matching both",
+			schema: `{"oneOf": [{"type": "integer"}, {"minimum": 0}]}`,
+			doc:    `1`,
+			want:   []string{"$: matches 2 schemas of oneOf instead of 1"},
+		},
+		{
+			name:   "oneOf matching one",
+			schema: `{"oneOf": [{"type": "integer"}, {"minimum": 0}]}`,
+			doc:    `-1`,
+		},
+		{
+			name:   "anyOf",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "boolean"}]}`,
+			doc:    `1`,
+			want:   []string{"$: doesn't match any schema of anyOf"},
+		},
+		{
+			name:   "draft 4 exclusive bounds",
+			schema: `{"minimum": 0, "exclusiveMinimum": true, "maximum": 10, "exclusiveMaximum": true}`,
+			doc:    `0`,
+			want:   []string{"$: isn't greater than 0"},
+		},
+		{
+			name:   "draft 4 exclusive bounds within",
+			schema: `{"minimum": 0, "exclusiveMinimum": true}`,
+			doc:    `0.5`,
+		},
+		{
+			name:   "numeric exclusive bounds",
+			schema: `{"exclusiveMaximum": 10}`,
+			doc:    `10`,
+			want:   []string{"$: isn't less than 10"},
+		},
+		{
+			name:   "prefixItems and items",
+			schema: `{"prefixItems": [{"type": "string"}], "items": {"type": "integer"}}`,
+			doc:    `[1, 2, "a"]`,
+			want: []string{
+				`$[0]: expected string, got integer`,
+				`$[2]: expected integer, got string`,
+			},
+		},
+		{
+			name:   "draft 4 items array and additionalItems",
+			schema: `{"items": [{"type": "string"}], "additionalItems": false}`,
+			doc:    `["a", 1]`,
+			want:   []string{"$[1]: no value is allowed"},
+		},
+		{
+			name:   "additionalProperties false",
+			schema: `{"properties": {"a": {}}, "patternProperties": {"^x-": {}}, "additionalProperties": false}`,
+			doc:    `{"a": 1, "x-b": 2, "c": 3}`,
+			want:   []string{`$: property "c" isn't allowed`},
+		},
+		{
+			name:   "additionalProperties schema",
+			schema: `{"additionalProperties": {"type": "string"}}`,
+			doc:    `{"a": "b", "my key": 1}`,
+			want:   []string{`$["my key"]: expected string, got integer`},
+		},
+		{
+			name:   "truncated at max",
+			schema: `{"required": ["a", "b", "c"]}`,
+			doc:    `{}`,
+			max:    2,
+			want: []string{
+				`$: missing required property "a"`,
+				`$: missing required property "b"`,
+			},
+		},
+		{
+			name:   "all without max",
+			schema: `{"required": ["a", "b", "c"]}`,
+			doc:    `{}`,
+			want: []string{
+				`$: missing required property "a"`,
+				`$: missing required property "b"`,
+				`$: missing required property "c"`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := ParseSchema([]byte(tc.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := decodeJSON([]byte(tc.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.Validate(doc, tc.max); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
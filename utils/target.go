@@ -1,6 +1,7 @@
 package gogeta
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -8,45 +9,137 @@ import (
 	"gopkg.in/yaml.v3"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"text/template"
+	"time"
 )
 
-func handleErrors(err error, msg string) {
-	if err != nil {
-		fmt.Println(msg)
-		os.Exit(1)
-	}
-}
-
+// TestConfig holds the attack options of a plan. Command line flags take
+// precedence over them.
 type TestConfig struct {
-	Workers int `json:"workers" yaml:"workers"`
+	Name        string        `json:"name" yaml:"name"`
+	Output      string        `json:"output" yaml:"output"`
+	Rate        *Rate         `json:"rate" yaml:"rate"`
+	Duration    time.Duration `json:"duration" yaml:"duration"`
+	Workers     uint64        `json:"workers" yaml:"workers"`
+	MaxWorkers  uint64        `json:"maxWorkers" yaml:"maxWorkers"`
+	Timeout     time.Duration `json:"timeout" yaml:"timeout"`
+	KeepAlive   *bool         `json:"keepalive" yaml:"keepalive"`
+	Connections int           `json:"connections" yaml:"connections"`
+	// Thresholds are the objectives the attack must meet to succeed.
+	Thresholds []Threshold `json:"thresholds" yaml:"thresholds"`
+	// AbortOnBreach stops the attack as soon as a Threshold is breached
+	// for good.
+	AbortOnBreach bool `json:"abortOnBreach" yaml:"abortOnBreach"`
 }
 
 type RequestConfig struct {
-	Method  string                 `yaml:"method"`
-	Url     string                 `yaml:"url"`
-	Headers map[string]string      `yaml:"headers"`
-	Body    map[string]interface{} `yaml:"body"`
-	Params  []string               `yaml:"params"`
+	Method  string            `yaml:"method"`
+	Url     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Body    *RequestBody      `yaml:"body"`
+	// Params fill the %s placeholders of the URL in order, $name params
+	// with the value of the variable name.
+	Params []string `yaml:"params"`
+	// Query holds query parameters added to the URL.
+	Query map[string]string `yaml:"query"`
 }
 
 type TargetSetup struct {
-	PreRun  interface{}   `yaml:"preRun"`
+	Name    string        `yaml:"name"`
+	PreRun  PreRun        `yaml:"preRun"`
 	Run     RequestConfig `yaml:"run"`
-	PostRun interface{}   `yaml:"postRun"`
+	PostRun PostRun       `yaml:"postRun"`
+	// Think is the pause taken after the step's response is read.
+	Think *ThinkTime `yaml:"think"`
+	// Checks are the assertions on the step's response.
+	Checks []Check `yaml:"checks"`
+}
+
+// PreRun holds the actions run before a step's request is built.
+type PreRun struct {
+	// CacheGet makes values stored by earlier steps available under a local
+	// name. Each entry maps the local name to the cache key, e.g.
+	// "uuid: txn_uuid" exposes the value stored as txn_uuid as ${uuid}.
+	CacheGet []map[string]string `yaml:"cache-get"`
+}
+
+// PostRun holds the actions run once a step's response has been read.
+type PostRun struct {
+	CacheSet []CacheSet `yaml:"cache-set"`
+}
+
+// CacheSet stores a value taken from a response under CacheKey. The value
+// is looked up by exactly one of ResponseKey (a JSONPath into the body, a
+// bare key addresses a top-level member), Header or Regex.
+type CacheSet struct {
+	ResponseKey string `yaml:"responseKey"`
+	Header      string `yaml:"header"`
+	Regex       string `yaml:"regex"`
+	CacheKey    string `yaml:"cacheKey"`
 }
 
 type Plan struct {
 	Name    string        `yaml:"name"`
 	Targets []TargetSetup `yaml:"targets"`
+	// Pacing is the minimum duration of an iteration of the plan. Faster
+	// iterations are padded with a pause at their end.
+	Pacing time.Duration `yaml:"pacing"`
+	// Weight is the plan's share of the iterations relative to the weights
+	// of the other plans. Plans without a weight count as 1.
+	Weight int `yaml:"weight"`
+	// Data feeds the iterations of the plan with rows of variables.
+	Data *DataSource `yaml:"data"`
+
+	// The settings below make the plan a scenario of its own, run
+	// concurrently with the other plans rather than sharing their rate.
+
+	// Rate is the constant rate of the plan's iterations, or the starting
+	// rate of its Stages.
+	Rate *Rate `yaml:"rate"`
+	// Stages ramps the rate of the plan's iterations, as with --pace=stages.
+	Stages []Stage `yaml:"stages"`
+	// Duration bounds the plan's scenario from its start.
+	Duration time.Duration `yaml:"duration"`
+	// StartDelay postpones the start of the plan's scenario.
+	StartDelay time.Duration `yaml:"startDelay"`
+	// Workers and MaxWorkers size the scenario's own pool of workers.
+	Workers    uint64 `yaml:"workers"`
+	MaxWorkers uint64 `yaml:"maxWorkers"`
+}
+
+// IsScenario reports whether the plan has settings of its own and so runs
+// as a separate Scenario.
+func (p *Plan) IsScenario() bool {
+	return p.Rate != nil || len(p.Stages) > 0 || p.Duration > 0 ||
+		p.StartDelay > 0 || p.Workers > 0 || p.MaxWorkers > 0
+}
+
+// Pacer returns the plan's own Pacer, or nil if it has neither a Rate nor
+// Stages.
+func (p *Plan) Pacer() Pacer {
+	switch {
+	case len(p.Stages) > 0:
+		return NewStagesPacer(p.Rate, p.Stages)
+	case p.Rate != nil:
+		return *p.Rate
+	default:
+		return nil
+	}
 }
 
 type Config struct {
 	ApiVersion    string     `json:"apiVersion" yaml:"apiVersion"`
 	Configuration TestConfig `json:"config" yaml:"config"`
 	TargetPlan    []Plan     `json:"targetPlan" yaml:"targetPlan"`
+
+	// Warnings lists the changes made to upgrade the plan from an older
+	// apiVersion.
+	Warnings []string `json:"-" yaml:"-"`
 }
 
 type Target struct {
@@ -54,7 +147,51 @@ type Target struct {
 	URL    string      `json:"url"`
 	Body   []byte      `json:"body,omitempty"`
 	Header http.Header `json:"header,omitempty"`
-	Next   *Target     `json:"next,omitempty"`
+	// Bind maps local variable names to the names of variables set by
+	// earlier steps of the chain.
+	Bind map[string]string `json:"bind,omitempty"`
+	// Form is a form sent URL encoded as the body instead of Body. In the
+	// Targets of plans, its values may reference variables as ${name}.
+	Form map[string]string `json:"form,omitempty"`
+	// Multipart is a multipart/form-data body sent instead of Body.
+	Multipart *Multipart `json:"multipart,omitempty"`
+	// File is the path of a file streamed as the body instead of Body.
+	File string `json:"file,omitempty"`
+	// Extract lists the values to pull out of this step's response. Like
+	// Checks, they're compiled when the Target is decoded or built out of a
+	// plan.
+	Extract []Extractor `json:"extract,omitempty"`
+	// Checks are the assertions on this step's response.
+	Checks []Check `json:"checks,omitempty"`
+	// Params fill the %s placeholders of the URL in order. A param of the
+	// form $name is replaced by the value of the variable name.
+	Params []string `json:"params,omitempty"`
+	// Query holds query parameters added to the URL. In the Targets of
+	// plans, their values may reference variables as ${name}.
+	Query map[string]string `json:"query,omitempty"`
+	Next  *Target           `json:"next,omitempty"`
+	// Plan is the name of the plan a chain belongs to. It is only read
+	// from the first Target of a chain.
+	Plan string `json:"plan,omitempty"`
+	// Name is the name of the step, used to tag its Result.
+	Name string `json:"name,omitempty"`
+	// Think is the pause taken after this step.
+	Think *ThinkTime `json:"think,omitempty"`
+	// Pacing is the minimum duration of an iteration of the chain. It is
+	// only read from the first Target of a chain.
+	Pacing time.Duration `json:"pacing,omitempty"`
+	// Weight is the share of the iterations given to the chain by
+	// NewWeightedTargeter. It is only read from the first Target of a chain.
+	Weight int `json:"weight,omitempty"`
+	// Data feeds every iteration of the chain with a row of variables. It
+	// is only read from the first Target of a chain.
+	Data *Feeder `json:"-"`
+
+	// templates holds the parsed templates of the request, by part.
+	templates map[string]*template.Template
+	// vars is set for the Targets of plans, whose requests reference
+	// variables as ${name}. Other Targets are sent verbatim.
+	vars bool
 }
 
 var (
@@ -68,8 +205,29 @@ var (
 	// ErrNoURL is returned by JSONTargeter when a parsed Target has no
 	// URL.
 	ErrNoURL = errors.New("target: required url is missing")
+	// ErrUnsetVar is returned when a request references a variable which
+	// no earlier step of its chain has set.
+	ErrUnsetVar = errors.New("variable is not set")
+	// ErrAPIVersion is returned by ReadConfig when a plan's apiVersion is
+	// missing or unsupported.
+	ErrAPIVersion = errors.New("unsupported apiVersion")
 )
 
+// A PlanError is returned by ProcessReader when a plan can't be turned
+// into Targets. Step is the 0-based index of the offending target.
+type PlanError struct {
+	Plan string
+	Step int
+	Err  error
+}
+
+func (e *PlanError) Error() string {
+	return fmt.Sprintf("plan %q, target %d: %v", e.Plan, e.Step, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *PlanError) Unwrap() error { return e.Err }
+
 // A Targeter decodes a Target or returns an error in case of failure.
 // Implementations must be safe for concurrent use.
 type Targeter func(*Target) error
@@ -80,6 +238,9 @@ func NewStaticTargeter(tgts ...Target) Targeter {
 		if tgt == nil {
 			return ErrNilTarget
 		}
+		if len(tgts) == 0 {
+			return ErrNoTargets
+		}
 		if tgt.Equal(&Target{}) {
 			*tgt = tgts[atomic.AddInt64(&i, 1)%int64(len(tgts))]
 
@@ -88,84 +249,590 @@ func NewStaticTargeter(tgts ...Target) Targeter {
 	}
 }
 
-func ProcessReader(reader io.Reader) []Target {
+// ReadAllTargets reads every Target of the given Targeter until it returns
+// ErrNoTargets, so that a finite source can be replayed in a loop by
+// NewStaticTargeter.
+func ReadAllTargets(tr Targeter) ([]Target, error) {
+	var tgts []Target
+	for {
+		var tgt Target
+		if err := tr(&tgt); errors.Is(err, ErrNoTargets) {
+			return tgts, nil
+		} else if err != nil {
+			return nil, err
+		}
+		tgts = append(tgts, tgt)
+	}
+}
+
+// NewWeightedTargeter returns a Targeter which hands out the given Targets in
+// proportion to their Weight, with a Weight of 0 counting as 1. The selection
+// is deterministic and spreads each Target's turns evenly: weights of 5, 1
+// and 1 yield the sequence a a b a c a a, rather than five a's in a row.
+func NewWeightedTargeter(tgts ...Target) Targeter {
+	var (
+		mu      sync.Mutex
+		total   int
+		weights = make([]int, len(tgts))
+		current = make([]int, len(tgts))
+	)
+
+	for i := range tgts {
+		if weights[i] = tgts[i].Weight; weights[i] <= 0 {
+			weights[i] = 1
+		}
+		total += weights[i]
+	}
+
+	return func(tgt *Target) error {
+		if tgt == nil {
+			return ErrNilTarget
+		}
+		if len(tgts) == 0 {
+			return ErrNoTargets
+		}
+
+		// Smooth weighted round-robin: every Target earns its weight on each
+		// pick, and the richest one is picked and pays back the total.
+		mu.Lock()
+		best := 0
+		for i := range current {
+			if current[i] += weights[i]; current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		mu.Unlock()
+
+		*tgt = tgts[best]
+		return nil
+	}
+}
+
+// A ParseError is returned by streaming Targeters when their input is
+// malformed. Line is the 1-based line number where the problem was found.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// NewHTTPTargeter returns a new Targeter that decodes one Target from the
+// given io.Reader on every invocation. The format is the one used by vegeta:
+//
+//	GET https://foo.bar/a/b/c
+//	Header-X: 123
+//	Header-Y: 321
+//	@/path/to/body/file
+//
+//	POST https://foo.bar/b/c/a
+//	Header-X: 123
+//
+// Targets are separated by blank lines and lines starting with # are
+// ignored. Targets are read lazily, so the source can be an endless stream,
+// and ErrNoTargets is returned once it's exhausted.
+func NewHTTPTargeter(src io.Reader) Targeter {
+	var mu sync.Mutex
+	sc := peekingScanner{src: bufio.NewScanner(src)}
+	return func(tgt *Target) (err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if tgt == nil {
+			return ErrNilTarget
+		}
+
+		var line string
+		for {
+			if !sc.Scan() {
+				if err = sc.Err(); err != nil {
+					return &ParseError{Line: sc.line, Err: err}
+				}
+				return ErrNoTargets
+			}
+			line = strings.TrimSpace(sc.Text())
+			if len(line) != 0 && line[0] != '#' {
+				break
+			}
+		}
+
+		*tgt = Target{Header: http.Header{}}
+
+		tokens := strings.SplitN(line, " ", 2)
+		if len(tokens) < 2 {
+			return &ParseError{Line: sc.line, Err: fmt.Errorf("bad target: %s", line)}
+		}
+		if !startsWithHTTPMethod(tokens[0]) {
+			return &ParseError{Line: sc.line, Err: fmt.Errorf("bad method: %s", tokens[0])}
+		}
+		tgt.Method = tokens[0]
+
+		tokens[1] = strings.TrimSpace(tokens[1])
+		if _, err = url.ParseRequestURI(tokens[1]); err != nil {
+			return &ParseError{Line: sc.line, Err: fmt.Errorf("bad URL: %s", tokens[1])}
+		}
+		tgt.URL = tokens[1]
+
+		line = strings.TrimSpace(sc.Peek())
+		if line == "" || startsWithHTTPMethod(line) {
+			return nil
+		}
+
+		for sc.Scan() {
+			if line = strings.TrimSpace(sc.Text()); line == "" {
+				break
+			} else if strings.HasPrefix(line, "#") {
+				continue
+			} else if strings.HasPrefix(line, "@") {
+				if tgt.Body, err = os.ReadFile(line[1:]); err != nil {
+					return &ParseError{Line: sc.line, Err: fmt.Errorf("bad body: %w", err)}
+				}
+				break
+			}
+
+			tokens = strings.SplitN(line, ":", 2)
+			if len(tokens) < 2 {
+				return &ParseError{Line: sc.line, Err: fmt.Errorf("bad header: %s", line)}
+			}
+			for i := range tokens {
+				if tokens[i] = strings.TrimSpace(tokens[i]); tokens[i] == "" {
+					return &ParseError{Line: sc.line, Err: fmt.Errorf("bad header: %s", line)}
+				}
+			}
+			// Add key/value directly to the http.Header (map[string][]string).
+			// http.Header.Add() canonicalizes keys but some systems under test
+			// require case-sensitive headers.
+			tgt.Header[tokens[0]] = append(tgt.Header[tokens[0]], tokens[1])
+		}
+
+		if err = sc.Err(); err != nil {
+			return &ParseError{Line: sc.line, Err: err}
+		}
+
+		return nil
+	}
+}
+
+// NewJSONTargeter returns a new Targeter that decodes one Target from the
+// given io.Reader on every invocation. Each line of the source must hold a
+// single JSON encoded Target, for example:
+//
+//	{"method":"POST","url":"http://foo.bar/txn","body":"eyJmbGFnIjp0cnVlfQ==","header":{"Content-Type":["application/json"]}}
+//	{"method":"GET","url":"http://foo.bar/a","next":{"method":"GET","url":"http://foo.bar/b"}}
+//
+// Bodies are base64 encoded and chained requests are nested under next.
+// Lines are only read when a Target is requested, so the source can be fed
+// by a generator without ever being buffered in full. ErrNoTargets is
+// returned once the source is exhausted.
+func NewJSONTargeter(src io.Reader) Targeter {
+	var (
+		mu   sync.Mutex
+		rd   = bufio.NewReader(src)
+		line int
+	)
+
+	return func(tgt *Target) (err error) {
+		if tgt == nil {
+			return ErrNilTarget
+		}
+
+		var data []byte
+
+		mu.Lock()
+		for len(data) == 0 {
+			if data, err = rd.ReadBytes('\n'); err != nil && (err != io.EOF || len(data) == 0) {
+				mu.Unlock()
+				if err == io.EOF {
+					return ErrNoTargets
+				}
+				return &ParseError{Line: line, Err: err}
+			}
+			line++
+			data = bytes.TrimSpace(data)
+		}
+		n := line
+		mu.Unlock()
+
+		var t Target
+		if err = json.Unmarshal(data, &t); err != nil {
+			return &ParseError{Line: n, Err: err}
+		}
+
+		for step := &t; step != nil; step = step.Next {
+			switch {
+			case step.Method == "":
+				return &ParseError{Line: n, Err: ErrNoMethod}
+			case step.URL == "":
+				return &ParseError{Line: n, Err: ErrNoURL}
+			}
+			for i := range step.Extract {
+				if err = step.Extract[i].compile(); err != nil {
+					return &ParseError{Line: n, Err: err}
+				}
+			}
+			for i := range step.Checks {
+				if err = step.Checks[i].compile(); err != nil {
+					return &ParseError{Line: n, Err: err}
+				}
+			}
+			if step.Think != nil {
+				if err = step.Think.validate(); err != nil {
+					return &ParseError{Line: n, Err: err}
+				}
+			}
+		}
+
+		*tgt = t
+		return nil
+	}
+}
+
+var httpMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+func startsWithHTTPMethod(t string) bool {
+	for _, method := range httpMethods {
+		if strings.HasPrefix(t, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// peekingScanner wraps a bufio.Scanner, allowing the next line to be
+// inspected without consuming it, and keeps count of the lines consumed.
+type peekingScanner struct {
+	src     *bufio.Scanner
+	text    string
+	peeked  string
+	hasPeek bool
+	line    int
+}
+
+func (s *peekingScanner) Err() error {
+	return s.src.Err()
+}
+
+func (s *peekingScanner) Peek() string {
+	if !s.hasPeek {
+		if !s.src.Scan() {
+			return ""
+		}
+		s.peeked, s.hasPeek = s.src.Text(), true
+	}
+	return s.peeked
+}
+
+func (s *peekingScanner) Scan() bool {
+	if s.hasPeek {
+		s.text, s.hasPeek = s.peeked, false
+	} else if s.src.Scan() {
+		s.text = s.src.Text()
+	} else {
+		return false
+	}
+	s.line++
+	return true
+}
+
+func (s *peekingScanner) Text() string {
+	return s.text
+}
+
+// ProcessReader reads a YAML plan from the given io.Reader and returns one
+// Target per plan, with the plan's remaining targets chained through Next.
+func ProcessReader(reader io.Reader) ([]Target, error) {
+	config, err := ReadConfig(reader)
+	if err != nil {
+		return nil, err
+	}
+	return config.Targets()
+}
+
+// ReadConfig reads a YAML plan from the given io.Reader.
+func ReadConfig(reader io.Reader) (*Config, error) {
 	res, err := io.ReadAll(reader)
-	handleErrors(err, fmt.Sprintf("Reading from STDIN failed with %v", err))
+	if err != nil {
+		return nil, fmt.Errorf("reading plan: %w", err)
+	}
 
 	var config Config
 
-	err = yaml.Unmarshal(res, &config)
-	handleErrors(err, fmt.Sprintf("Failure in unmarshalling plan %v", err))
+	if err = yaml.Unmarshal(res, &config); err != nil {
+		return nil, fmt.Errorf("unmarshalling plan: %w", err)
+	}
 
-	var tgts []Target
+	if err = config.upgrade(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Targets returns the Target chain of every plan of the Config.
+func (c *Config) Targets() ([]Target, error) {
+	tgts := make([]Target, 0, len(c.TargetPlan))
+	for i := range c.TargetPlan {
+		if len(c.TargetPlan[i].Targets) == 0 {
+			continue
+		}
+		tgt, err := c.TargetPlan[i].Target()
+		if err != nil {
+			return nil, err
+		}
+		tgts = append(tgts, tgt)
+	}
+	return tgts, nil
+}
+
+// Target returns the first Target of the plan, with the plan's remaining
+// targets chained through Next.
+func (p *Plan) Target() (Target, error) {
+	if p.Weight < 0 {
+		return Target{}, &PlanError{Plan: p.Name, Err: fmt.Errorf("negative weight %d", p.Weight)}
+	}
+
+	var feeder *Feeder
+	if p.Data != nil {
+		var err error
+		if feeder, err = p.Data.Feeder(); err != nil {
+			return Target{}, &PlanError{Plan: p.Name, Err: err}
+		}
+	}
+
+	var head Target
+	tail := &head
+	for targetIndex := range p.Targets {
+		setup := &p.Targets[targetIndex]
+
+		tgt := Target{
+			Method: setup.Run.Method,
+			URL:    setup.Run.Url,
+			Next:   nil,
+			Plan:   p.Name,
+			Name:   setup.Name,
+			Think:  setup.Think,
+			Params: setup.Run.Params,
+			Query:  setup.Run.Query,
+			vars:   true,
+		}
+		if targetIndex == 0 {
+			tgt.Pacing = p.Pacing
+			tgt.Weight = p.Weight
+			tgt.Data = feeder
+		}
+		tgt.Header = http.Header{}
+		for k, v := range setup.Run.Headers {
+			tgt.Header.Add(k, v)
+		}
+
+		if setup.Run.Body != nil {
+			if err := setup.Run.Body.apply(&tgt); err != nil {
+				return Target{}, &PlanError{Plan: p.Name, Step: targetIndex, Err: err}
+			}
+		}
+
+		if err := tgt.compileTemplates(); err != nil {
+			return Target{}, &PlanError{Plan: p.Name, Step: targetIndex, Err: err}
+		}
 
-	for _, plan := range config.TargetPlan {
-		for targetIndex := range plan.Targets {
-			marshalledBody, err := json.Marshal(plan.Targets[targetIndex].Run.Body)
-			handleErrors(err, fmt.Sprintf("Failure in marshalling Body %v", err))
+		for _, get := range setup.PreRun.CacheGet {
+			for local, key := range get {
+				if tgt.Bind == nil {
+					tgt.Bind = map[string]string{}
+				}
+				tgt.Bind[local] = key
+			}
+		}
 
-			tgt := Target{
-				Method: plan.Targets[targetIndex].Run.Method,
-				URL:    plan.Targets[targetIndex].Run.Url,
-				Body:   marshalledBody,
-				Next:   nil,
+		for _, set := range setup.PostRun.CacheSet {
+			x := Extractor{
+				Var:      set.CacheKey,
+				JSONPath: set.ResponseKey,
+				Header:   set.Header,
+				Regex:    set.Regex,
 			}
-			tgt.Header = http.Header{}
-			for k, v := range plan.Targets[targetIndex].Run.Headers {
-				tgt.Header.Add(k, v)
+			if err := x.compile(); err != nil {
+				return Target{}, &PlanError{Plan: p.Name, Step: targetIndex, Err: err}
 			}
+			tgt.Extract = append(tgt.Extract, x)
+		}
 
-			if targetIndex != 0 {
-				tgts[(len(tgts) - 1)].Next = &tgt
-			} else {
-				tgts = append(tgts, tgt)
+		tgt.Checks = append([]Check(nil), setup.Checks...)
+		for i := range tgt.Checks {
+			if err := tgt.Checks[i].compile(); err != nil {
+				return Target{}, &PlanError{Plan: p.Name, Step: targetIndex, Err: err}
 			}
 		}
+
+		if targetIndex == 0 {
+			head = tgt
+		} else {
+			tail.Next = &tgt
+			tail = tail.Next
+		}
 	}
-	return tgts
+	return head, nil
 }
 
-func substituteURL(url string, values ...any) (string, error) {
-	if !strings.Contains(url, "%s") {
-		// No placeholders, return the original URL
-		return url, nil
+// substituteURL fills the %s placeholders of the given URL with the given
+// values, in order, escaping them as path segments. Variables referenced by
+// the URL itself are expanded from the Scope.
+func (t *Target) substituteURL(scope *Scope, rawURL string, values ...string) (string, error) {
+	parts := strings.Split(rawURL, "%s")
+	if len(parts)-1 != len(values) {
+		return "", fmt.Errorf("url %q has %d %%s placeholders but %d params", rawURL, len(parts)-1, len(values))
 	}
 
-	if len(values) == 0 {
-		return "", fmt.Errorf("url contains placeholders (%s) but no values provided")
+	var out strings.Builder
+	for i, part := range parts {
+		expanded, err := t.expand(scope, part)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			out.WriteString(url.PathEscape(values[i-1]))
+		}
+		out.WriteString(expanded)
 	}
 
-	// Escape the URL to avoid unintended substitution of other characters
-	escapedURL := strings.ReplaceAll(url, "%", "%%")
+	return out.String(), nil
+}
+
+// param resolves a URL param: $name is the value of the variable name and
+// anything else is expanded as a string.
+func (t *Target) param(scope *Scope, p string) (string, error) {
+	if name, ok := paramVar(p); ok {
+		val, ok := scope.Vars[name]
+		if !ok {
+			return "", fmt.Errorf("param %q: %w", name, ErrUnsetVar)
+		}
+		return val, nil
+	}
+	return t.expand(scope, p)
+}
 
-	// Format the URL with the provided values
-	formattedURL := fmt.Sprintf(escapedURL, values...)
+// paramVar returns the name of the variable a param of the form $name refers
+// to, or false if the param isn't one.
+func paramVar(p string) (string, bool) {
+	name, ok := strings.CutPrefix(p, "$")
+	return name, ok && name != "" && !strings.HasPrefix(name, "{")
+}
 
-	// Unescape the formatted URL
-	return strings.ReplaceAll(formattedURL, "%%", "%"), nil
+// expand substitutes the variables of the Scope referenced by s if the
+// Target is from a plan, and returns s verbatim otherwise.
+func (t *Target) expand(scope *Scope, s string) (string, error) {
+	if !t.vars {
+		return s, nil
+	}
+	return scope.Expand(s)
 }
 
 // Request creates an *http.Request out of Target and returns it along with an
 // error in case of failure.
-func (t *Target) Request(cache map[string]string) (*http.Request, error) {
-	var body io.Reader
-	if len(t.Body) != 0 {
-		body = bytes.NewReader(t.Body)
+// The request templates of the Target are executed and, for the Targets of
+// plans, variables of the given Scope referenced as ${name} are substituted
+// into the URL, header values and body.
+func (t *Target) Request(scope *Scope) (*http.Request, error) {
+	body, length, contentType, err := t.body(scope)
+	if err != nil {
+		return nil, err
 	}
 
-	val, ok := cache["txn_uuid"]
-	if ok && strings.Contains(t.URL, "%s") {
-		t.URL = fmt.Sprintf(t.URL, val)
+	// The body is closed by the client once sent, or right here if the
+	// request can't be built.
+	req, err := t.request(scope, body)
+	if err != nil {
+		if c, ok := body.(io.Closer); ok {
+			c.Close()
+		}
+		return nil, err
 	}
 
-	req, err := http.NewRequest(t.Method, t.URL, body)
+	if body != nil {
+		req.ContentLength = length
+		if length == 0 {
+			// An empty file would never be read, and so never closed.
+			if c, ok := body.(io.Closer); ok {
+				c.Close()
+			}
+			req.Body = http.NoBody
+		}
+	}
+
+	if contentType != "" && (strings.HasPrefix(contentType, "multipart/") || req.Header.Get("Content-Type") == "") {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return req, nil
+}
+
+// request builds the Target's request around the given body.
+func (t *Target) request(scope *Scope, body io.Reader) (*http.Request, error) {
+	params := make([]string, len(t.Params))
+	for i, p := range t.Params {
+		var err error
+		if params[i], err = t.param(scope, p); err != nil {
+			return nil, err
+		}
+	}
+
+	// The Target is shared by every iteration, so the URL is built anew
+	// rather than filled in place.
+	rawURL, err := t.execute(scope, "url", t.URL)
 	if err != nil {
 		return nil, err
 	}
+	if rawURL, err = t.substituteURL(scope, rawURL, params...); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(t.Method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.Query) > 0 {
+		query := url.Values{}
+		for k, v := range t.Query {
+			if v, err = t.execute(scope, "query/"+k, v); err != nil {
+				return nil, err
+			}
+			if v, err = t.expand(scope, v); err != nil {
+				return nil, err
+			}
+			query.Set(k, v)
+		}
+		if req.URL.RawQuery != "" {
+			req.URL.RawQuery += "&"
+		}
+		req.URL.RawQuery += query.Encode()
+	}
 
 	for k, vs := range t.Header {
 		req.Header[k] = make([]string, len(vs))
-		copy(req.Header[k], vs)
+		for i := range vs {
+			v, err := t.execute(scope, fmt.Sprintf("header/%s/%d", k, i), vs[i])
+			if err != nil {
+				return nil, err
+			}
+			if req.Header[k][i], err = t.expand(scope, v); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if host := req.Header.Get("Host"); host != "" {
@@ -0,0 +1,95 @@
+package gogeta
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHTTPTargeter(t *testing.T) {
+	t.Parallel()
+
+	body := filepath.Join(t.TempDir(), "body.json")
+	if err := os.WriteFile(body, []byte(`{"a":1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := `# comment
+GET http://foo.bar/a
+X-Account: 1
+X-Account: 2
+x-lower: kept
+
+POST http://foo.bar/b
+Content-Type: application/json
+# comment
+@` + body + `
+GET http://foo.bar/c
+`
+
+	want := []Target{
+		{Method: "GET", URL: "http://foo.bar/a", Header: http.Header{"X-Account": {"1", "2"}, "x-lower": {"kept"}}},
+		{Method: "POST", URL: "http://foo.bar/b", Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"a":1}`)},
+		{Method: "GET", URL: "http://foo.bar/c", Header: http.Header{}},
+	}
+
+	tr := NewHTTPTargeter(strings.NewReader(src))
+	for i, w := range want {
+		var got Target
+		if err := tr(&got); err != nil {
+			t.Fatalf("target %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("target %d: got %+v, want %+v", i, got, w)
+		}
+	}
+
+	if err := tr(&Target{}); !errors.Is(err, ErrNoTargets) {
+		t.Errorf("got %v at the end, want %v", err, ErrNoTargets)
+	}
+}
+
+func TestHTTPTargeterErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		src  string
+		line int
+		err  string
+	}{
+		{"GET", 1, "bad target: GET"},
+		{"FETCH http://foo.bar", 1, "bad method: FETCH"},
+		{"GET foo.bar", 1, "bad URL: foo.bar"},
+		{"GET http://foo.bar\nX-Header 1", 2, "bad header: X-Header 1"},
+		{"GET http://foo.bar\nX-Header:", 2, "bad header: X-Header:"},
+		{"\n\nGET http://foo.bar\n@/does/not/exist", 4, "bad body: open /does/not/exist: no such file or directory"},
+	} {
+		err := NewHTTPTargeter(strings.NewReader(tc.src))(&Target{})
+
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Line != tc.line || pe.Err.Error() != tc.err {
+			t.Errorf("%q: got %v, want line %d: %s", tc.src, err, tc.line, tc.err)
+		}
+	}
+}
+
+func TestTargetRequestVerbatim(t *testing.T) {
+	t.Parallel()
+
+	// Only the Targets of plans reference variables.
+	tgt := Target{Method: "POST", URL: "http://foo.bar/${a}", Header: http.Header{"X-A": {"${a}"}}, Body: []byte("echo ${HOME}")}
+	req, err := tgt.Request(NewScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(req.Body)
+	if got := req.URL.String() + " " + req.Header.Get("X-A") + " " + string(body); got != "http://foo.bar/$%7Ba%7D ${a} echo ${HOME}" {
+		t.Errorf("got %s", got)
+	}
+}
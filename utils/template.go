@@ -0,0 +1,223 @@
+package gogeta
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Request templates are text/template templates in the URL, header values,
+// query values and body of a plan's steps, which make every request unique:
+//
+//	url: "http://api/users/{{ randInt 1 1000 }}"
+//	headers: {X-Request-Id: "{{ uuid }}"}
+//	body: {name: "{{ randString 8 }}", at: "{{ timestampMillis }}", by: "{{ vu }}"}
+//
+// They're parsed once when the plan is loaded and executed for every request,
+// before ${name} variables are substituted. Besides the builtins of
+// text/template, they can call:
+//
+//	uuid             a random UUID (version 4)
+//	randInt min max  a random integer between min and max, both included
+//	randString n     a random alphanumeric string of n characters
+//	now              the current time.Time
+//	timestampMillis  the current Unix time in milliseconds
+//	seq              the sequence number of the iteration (X-Gogeta-Seq)
+//	vu               the number of the worker running the iteration within
+//	                 its scenario, from 1
+//	env name         the value of the environment variable name
+//	var name         the value of the variable name of the iteration
+//
+// The Scope of the iteration is the data of the templates, so {{ .Vars.id }}
+// works as well.
+
+// templates holds a worker's clones of request templates, with their
+// functions bound to the Scope of the worker's current iteration, so that
+// templates are cloned once per worker rather than for every request.
+type templates struct {
+	scope  *Scope
+	clones map[*template.Template]*template.Template
+}
+
+// clone returns the clone of the given template bound to the Scope.
+func (ts *templates) clone(tmpl *template.Template, scope *Scope) (*template.Template, error) {
+	ts.scope = scope
+	if clone, ok := ts.clones[tmpl]; ok {
+		return clone, nil
+	}
+
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	clone.Funcs(templateFuncs(ts))
+	if ts.clones == nil {
+		ts.clones = map[*template.Template]*template.Template{}
+	}
+	ts.clones[tmpl] = clone
+	return clone, nil
+}
+
+// templateFuncs returns the functions of request templates bound to the
+// current Scope of the given templates.
+func templateFuncs(ts *templates) template.FuncMap {
+	return template.FuncMap{
+		"uuid":            newUUID,
+		"randInt":         randInt,
+		"randString":      randString,
+		"now":             time.Now,
+		"timestampMillis": func() int64 { return time.Now().UnixMilli() },
+		"seq":             func() uint64 { return ts.scope.Seq },
+		"vu":              func() uint64 { return ts.scope.VU },
+		"env":             os.Getenv,
+		"var": func(name string) (string, error) {
+			val, ok := ts.scope.Vars[name]
+			if !ok {
+				return "", fmt.Errorf("%q: %w", name, ErrUnsetVar)
+			}
+			return val, nil
+		},
+	}
+}
+
+// parseTemplate parses the given request template, returning nil if it has
+// no actions.
+func parseTemplate(name, text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	return template.New(name).Funcs(templateFuncs(&templates{})).Option("missingkey=error").Parse(text)
+}
+
+// executeTemplate runs a parsed request template with the functions bound
+// to the given Scope. The functions depend on the Scope, so they're bound to
+// a clone of the shared template, kept by the worker the Scope belongs to.
+func executeTemplate(tmpl *template.Template, scope *Scope) (string, error) {
+	ts := scope.templates
+	if ts == nil {
+		ts = &templates{}
+	}
+	clone, err := ts.clone(tmpl, scope)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err = clone.Execute(&out, scope); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// compileTemplates parses the templates of the Target's request, so that
+// Request only has to execute them.
+func (t *Target) compileTemplates() error {
+	parts := map[string]string{"url": t.URL, "body": string(t.Body)}
+	for k, vs := range t.Header {
+		for i, v := range vs {
+			parts[fmt.Sprintf("header/%s/%d", k, i)] = v
+		}
+	}
+	for k, v := range t.Query {
+		parts["query/"+k] = v
+	}
+	for k, v := range t.Form {
+		parts["form/"+k] = v
+	}
+	if t.Multipart != nil {
+		for k, v := range t.Multipart.Fields {
+			parts["multipart/"+k] = v
+		}
+	}
+
+	for name, text := range parts {
+		tmpl, err := parseTemplate(name, text)
+		if err != nil {
+			return err
+		}
+		if tmpl == nil {
+			continue
+		}
+		if t.templates == nil {
+			t.templates = map[string]*template.Template{}
+		}
+		t.templates[name] = tmpl
+	}
+
+	return nil
+}
+
+// execute returns the given part of the Target's request with its template
+// executed, or as is if it has none.
+func (t *Target) execute(scope *Scope, part, text string) (string, error) {
+	tmpl, ok := t.templates[part]
+	if !ok {
+		return text, nil
+	}
+	return executeTemplate(tmpl, scope)
+}
+
+// unescapeActions undoes the JSON escaping of the quotes and backslashes in
+// the template actions of a JSON encoded body, so that e.g. {{ env "HOME" }}
+// in a string of the body still parses.
+func unescapeActions(body []byte) []byte {
+	if !bytes.Contains(body, []byte("{{")) {
+		return body
+	}
+
+	var (
+		out  bytes.Buffer
+		rest = body
+	)
+	for {
+		start := bytes.Index(rest, []byte("{{"))
+		if start == -1 {
+			break
+		}
+		end := bytes.Index(rest[start:], []byte("}}"))
+		if end == -1 {
+			break
+		}
+		end += start + 2
+
+		out.Write(rest[:start])
+		action := bytes.ReplaceAll(rest[start:end], []byte(`\"`), []byte(`"`))
+		out.Write(bytes.ReplaceAll(action, []byte(`\\`), []byte(`\`)))
+		rest = rest[end:]
+	}
+	out.Write(rest)
+
+	return out.Bytes()
+}
+
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func randInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randInt: max %d is lower than min %d", max, min)
+	}
+	return min + mrand.Intn(max-min+1), nil
+}
+
+const alphanumerics = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func randString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphanumerics[mrand.Intn(len(alphanumerics))]
+	}
+	return string(b)
+}
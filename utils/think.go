@@ -0,0 +1,126 @@
+package gogeta
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// ThinkTime describes the pause a user takes after a step of a plan before
+// moving on to the next one.
+//
+// In a plan it's either a duration ("2s"), a uniform range ("1s-3s") or a
+// mapping picking a distribution:
+//
+//	think: {dist: normal, mean: 2s, stddev: 500ms, min: 500ms}
+//	think: {dist: exponential, mean: 2s, max: 10s}
+type ThinkTime struct {
+	// Dist is the distribution pauses are drawn from: fixed, uniform,
+	// normal or exponential.
+	Dist string `json:"dist,omitempty" yaml:"dist"`
+	// Min is the length of fixed pauses and the lower bound of the others.
+	Min time.Duration `json:"min,omitempty" yaml:"min"`
+	// Max is the upper bound of pauses, if set.
+	Max time.Duration `json:"max,omitempty" yaml:"max"`
+	// Mean is the mean of normal and exponential pauses.
+	Mean time.Duration `json:"mean,omitempty" yaml:"mean"`
+	// StdDev is the standard deviation of normal pauses.
+	StdDev time.Duration `json:"stddev,omitempty" yaml:"stddev"`
+}
+
+// Think time distributions.
+const (
+	ThinkFixed       = "fixed"
+	ThinkUniform     = "uniform"
+	ThinkNormal      = "normal"
+	ThinkExponential = "exponential"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, accepting the
+// scalar shorthands besides the full mapping.
+func (t *ThinkTime) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		type plain ThinkTime
+		if err := node.Decode((*plain)(t)); err != nil {
+			return err
+		}
+		if t.Dist == "" {
+			t.Dist = ThinkFixed
+			if t.Max > 0 {
+				t.Dist = ThinkUniform
+			}
+		}
+		return t.validate()
+	}
+
+	lo, hi, isRange := strings.Cut(node.Value, "-")
+
+	min, err := time.ParseDuration(strings.TrimSpace(lo))
+	if err != nil {
+		return fmt.Errorf("think: %w", err)
+	}
+	*t = ThinkTime{Dist: ThinkFixed, Min: min}
+
+	if isRange {
+		if t.Max, err = time.ParseDuration(strings.TrimSpace(hi)); err != nil {
+			return fmt.Errorf("think: %w", err)
+		}
+		t.Dist = ThinkUniform
+	}
+
+	return t.validate()
+}
+
+func (t *ThinkTime) validate() error {
+	switch {
+	case t.Min < 0 || t.Max < 0 || t.Mean < 0 || t.StdDev < 0:
+		return fmt.Errorf("think: durations can't be negative")
+	case t.Max > 0 && t.Max < t.Min:
+		return fmt.Errorf("think: max %s is lower than min %s", t.Max, t.Min)
+	}
+
+	switch t.Dist {
+	case "", ThinkFixed:
+	case ThinkUniform:
+		if t.Max == 0 {
+			return fmt.Errorf("think: uniform distribution needs a max")
+		}
+	case ThinkNormal, ThinkExponential:
+		if t.Mean == 0 {
+			return fmt.Errorf("think: %s distribution needs a mean", t.Dist)
+		}
+	default:
+		return fmt.Errorf("think: unknown distribution %q", t.Dist)
+	}
+
+	return nil
+}
+
+// Sample draws the length of a pause.
+func (t *ThinkTime) Sample() time.Duration {
+	var d time.Duration
+	switch t.Dist {
+	case ThinkUniform:
+		d = t.Min
+		if t.Max > t.Min {
+			d += time.Duration(rand.Int63n(int64(t.Max - t.Min + 1)))
+		}
+	case ThinkNormal:
+		d = t.Mean + time.Duration(rand.NormFloat64()*float64(t.StdDev))
+	case ThinkExponential:
+		d = time.Duration(rand.ExpFloat64() * float64(t.Mean))
+	default:
+		d = t.Min
+	}
+
+	if d < t.Min {
+		d = t.Min
+	}
+	if t.Max > 0 && d > t.Max {
+		d = t.Max
+	}
+
+	return d
+}
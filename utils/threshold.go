@@ -0,0 +1,307 @@
+package gogeta
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A Threshold is a service level objective on the Metrics of an attack, such
+// as:
+//
+//	p99<300ms              a latency: min, mean, max or any pNN percentile
+//	response.p99<1s        a response time, from the intended start of the
+//	                       requests, rather than their latency
+//	success>=99.5%         the ratio of successful requests
+//	rate>=0.95*target      the rate or throughput, in hits/s or relative to
+//	                       the rate the attack aimed for
+//	errors[timeout]<10     the number of errors containing timeout, or of
+//	                       all errors without brackets, or their share as %
+//	requests>=1000         the number of requests
+//
+// The comparison operator is one of <, <=, >, >=, == or !=.
+type Threshold struct {
+	// Metric is the name of the compared metric, e.g. p99 or errors.
+	Metric string
+	// Arg is the argument of the metric between brackets, if any.
+	Arg string
+	// Op is the comparison operator.
+	Op string
+	// Value is the bound of the metric, in nanoseconds for latencies and
+	// as a ratio for percentages.
+	Value float64
+	// Relative makes Value a multiple of the target rate of the attack.
+	Relative bool
+	// Ratio compares the share of matching errors rather than their count.
+	Ratio bool
+	// Response compares response times rather than latencies.
+	Response bool
+
+	text string
+}
+
+// ErrThresholdsBreached is returned when an attack breaches some of its
+// Thresholds.
+var ErrThresholdsBreached = errors.New("thresholds breached")
+
+var thresholdRe = regexp.MustCompile(`^\s*(response\.)?([a-z]+[0-9.]*)(?:\[([^\]]*)\])?\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+// ParseThreshold parses a Threshold from its textual form, e.g. p99<300ms.
+func ParseThreshold(s string) (Threshold, error) {
+	m := thresholdRe.FindStringSubmatch(s)
+	if m == nil {
+		return Threshold{}, fmt.Errorf("threshold %q isn't of the form metric<value", s)
+	}
+
+	t := Threshold{Metric: m[2], Arg: m[3], Op: m[4], Response: m[1] != "", text: strings.TrimSpace(s)}
+	value := m[5]
+
+	if m[3] != "" && t.Metric != "errors" {
+		return Threshold{}, fmt.Errorf("threshold %q: only errors takes an argument", s)
+	}
+	if t.Response && t.kind() != "latency" {
+		return Threshold{}, fmt.Errorf("threshold %q: only latencies have response times", s)
+	}
+
+	var err error
+	switch kind := t.kind(); kind {
+	case "latency":
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		t.Value = float64(d)
+	case "ratio":
+		t.Value, err = parseRatio(value)
+	case "rate":
+		if factor, ok := strings.CutSuffix(value, "target"); ok {
+			t.Relative = true
+			factor = strings.TrimSuffix(factor, "*")
+			if t.Value = 1; factor != "" {
+				t.Value, err = strconv.ParseFloat(factor, 64)
+			}
+		} else {
+			t.Value, err = strconv.ParseFloat(value, 64)
+		}
+	case "count":
+		if strings.HasSuffix(value, "%") && t.Metric == "errors" {
+			t.Ratio = true
+			t.Value, err = parseRatio(value)
+		} else {
+			var n uint64
+			n, err = strconv.ParseUint(value, 10, 64)
+			t.Value = float64(n)
+		}
+	default:
+		return Threshold{}, fmt.Errorf("threshold %q: unknown metric %q", s, t.Metric)
+	}
+	if err != nil {
+		return Threshold{}, fmt.Errorf("threshold %q: bad value %q", s, value)
+	}
+
+	return t, nil
+}
+
+// kind returns the kind of the Threshold's metric.
+func (t *Threshold) kind() string {
+	switch t.Metric {
+	case "min", "mean", "max":
+		return "latency"
+	case "success":
+		return "ratio"
+	case "rate", "throughput":
+		return "rate"
+	case "errors", "requests":
+		return "count"
+	}
+	if q, ok := t.quantile(); ok && q > 0 && q < 1 {
+		return "latency"
+	}
+	return ""
+}
+
+// quantile returns the quantile of a pNN metric, e.g. 0.999 for p99.9.
+func (t *Threshold) quantile() (float64, bool) {
+	digits, ok := strings.CutPrefix(t.Metric, "p")
+	if !ok || digits == "" {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(digits, 64)
+	return p / 100, err == nil
+}
+
+func parseRatio(s string) (float64, error) {
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(pct, 64)
+		return v / 100, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// String returns the Threshold as it was given.
+func (t Threshold) String() string { return t.text }
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (t *Threshold) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := ParseThreshold(s)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// actual returns the value of the Threshold's metric in the given Metrics.
+func (t *Threshold) actual(m *Metrics) float64 {
+	lat := &m.Latencies
+	if t.Response {
+		lat = &m.ResponseTimes
+	}
+
+	switch t.Metric {
+	case "min":
+		return float64(lat.Min)
+	case "mean":
+		return float64(lat.Mean)
+	case "max":
+		return float64(lat.Max)
+	case "success":
+		return m.Success
+	case "rate":
+		return m.Rate
+	case "throughput":
+		return m.Throughput
+	case "requests":
+		return float64(m.Requests)
+	case "errors":
+		n := float64(m.ErrorCount(t.Arg))
+		if t.Ratio {
+			if m.Requests == 0 {
+				return 0
+			}
+			return n / float64(m.Requests)
+		}
+		return n
+	}
+	q, _ := t.quantile()
+	return float64(lat.Quantile(q))
+}
+
+func (t *Threshold) format(v float64) string {
+	switch {
+	case t.kind() == "latency":
+		return round(time.Duration(v)).String()
+	case t.kind() == "ratio" || t.Ratio:
+		return fmt.Sprintf("%.2f%%", v*100)
+	case t.kind() == "rate":
+		return fmt.Sprintf("%.2f/s", v)
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+func compare(a float64, op string, b float64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "==":
+		return a == b
+	default:
+		return a != b
+	}
+}
+
+// ThresholdResult is the outcome of a Threshold on the Metrics of an attack.
+type ThresholdResult struct {
+	Threshold Threshold
+	// Actual is the value of the metric, formatted like the Threshold.
+	Actual string
+	Pass   bool
+	// Err explains why the Threshold couldn't be evaluated.
+	Err error
+}
+
+// Evaluate evaluates the Threshold against the given closed Metrics. The
+// target rate is the rate the attack aimed for, zero if unknown.
+func (t Threshold) Evaluate(m *Metrics, target float64) ThresholdResult {
+	bound := t.Value
+	if t.Relative {
+		// NaN targets come from zero Rates.
+		if !(target > 0) {
+			return ThresholdResult{Threshold: t, Err: fmt.Errorf("the target rate is unknown")}
+		}
+		bound *= target
+	}
+
+	v := t.actual(m)
+	return ThresholdResult{Threshold: t, Actual: t.format(v), Pass: compare(v, t.Op, bound)}
+}
+
+// Breached reports whether the Threshold is breached by the given, possibly
+// still open, Metrics in a way no further Results can undo: an upper bound
+// on a metric which only ever grows, like the number of errors or the
+// maximum latency.
+func (t Threshold) Breached(m *Metrics) bool {
+	if t.Op != "<" && t.Op != "<=" {
+		return false
+	}
+	switch {
+	case t.Metric == "max", t.Metric == "requests", t.Metric == "errors" && !t.Ratio:
+		return !compare(t.actual(m), t.Op, t.Value)
+	}
+	return false
+}
+
+// EvaluateThresholds evaluates the given Thresholds against the given closed
+// Metrics, returning ErrThresholdsBreached if any of them doesn't pass.
+func EvaluateThresholds(ts []Threshold, m *Metrics, target float64) ([]ThresholdResult, error) {
+	results := make([]ThresholdResult, len(ts))
+	failed := 0
+	for i, t := range ts {
+		if results[i] = t.Evaluate(m, target); !results[i].Pass {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d %w", failed, len(ts), ErrThresholdsBreached)
+	}
+	return results, nil
+}
+
+// WriteThresholds writes a table of the given ThresholdResults.
+func WriteThresholds(w io.Writer, results []ThresholdResult) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.StripEscape)
+	if _, err := fmt.Fprintln(tw, "Thresholds\t[actual]\t[result]"); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		actual, result := r.Actual, "pass"
+		switch {
+		case r.Err != nil:
+			actual, result = "-", "FAIL ("+r.Err.Error()+")"
+		case !r.Pass:
+			result = "FAIL"
+		}
+		if _, err := fmt.Fprintf(tw, "  %s\t%s\t%s\n", r.Threshold, actual, result); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
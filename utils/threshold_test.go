@@ -0,0 +1,99 @@
+package gogeta
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in   string
+		want Threshold
+	}{
+		{"p99<300ms", Threshold{Metric: "p99", Op: "<", Value: float64(300 * time.Millisecond)}},
+		{" p99.9 <= 1s ", Threshold{Metric: "p99.9", Op: "<=", Value: float64(time.Second)}},
+		{"response.max<2s", Threshold{Metric: "max", Op: "<", Value: float64(2 * time.Second), Response: true}},
+		{"success>=99.5%", Threshold{Metric: "success", Op: ">=", Value: 0.995}},
+		{"success>0.9", Threshold{Metric: "success", Op: ">", Value: 0.9}},
+		{"rate>=0.95*target", Threshold{Metric: "rate", Op: ">=", Value: 0.95, Relative: true}},
+		{"throughput==target", Threshold{Metric: "throughput", Op: "==", Value: 1, Relative: true}},
+		{"rate>100", Threshold{Metric: "rate", Op: ">", Value: 100}},
+		{"errors[timeout]<10", Threshold{Metric: "errors", Arg: "timeout", Op: "<", Value: 10}},
+		{"errors<1%", Threshold{Metric: "errors", Op: "<", Value: 0.01, Ratio: true}},
+		{"requests!=0", Threshold{Metric: "requests", Op: "!=", Value: 0}},
+	} {
+		got, err := ParseThreshold(tc.in)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		tc.want.text = got.text
+		if got != tc.want {
+			t.Errorf("%q: got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseThresholdErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, in := range []string{
+		"p99",                // no operator
+		"p99=<1s",            // unknown operator
+		"p100<1s",            // not a quantile
+		"latency<1s",         // unknown metric
+		"p99[x]<1s",          // argument of a metric other than errors
+		"response.success>1", // response time of a metric other than latencies
+		"p99<300",            // latency without a unit
+		"requests<1.5",       // fractional count
+		"requests<1%",        // share of requests
+		"rate>=x*target",     // bad factor
+	} {
+		if _, err := ParseThreshold(in); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestThresholdBreached(t *testing.T) {
+	t.Parallel()
+
+	var m Metrics
+	for i := 0; i < 10; i++ {
+		r := &Result{Code: 200, Timestamp: time.Unix(int64(i), 0), Latency: time.Duration(i+1) * 10 * time.Millisecond}
+		if i < 3 {
+			r.Code, r.Error = 0, "net/http: timeout awaiting response headers"
+		}
+		m.Add(r)
+	}
+
+	for _, tc := range []struct {
+		threshold string
+		want      bool
+	}{
+		// Upper bounds on metrics which only ever grow can't recover.
+		{"max<100ms", true},
+		{"max<=100ms", false},
+		{"requests<10", true},
+		{"requests<=10", false},
+		{"errors<3", true},
+		{"errors[timeout]<=2", true},
+		{"errors[refused]<1", false},
+		// Other metrics may still change with further Results.
+		{"errors<20%", false},
+		{"p50<10ms", false},
+		{"mean<10ms", false},
+		{"success>=90%", false},
+		{"requests>20", false},
+	} {
+		th, err := ParseThreshold(tc.threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := th.Breached(&m); got != tc.want {
+			t.Errorf("%s: got breached %t, want %t", tc.threshold, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package gogeta
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Problem is a mistake found in a plan by ValidatePlan, located at the
+// line and column of the YAML node it's about. Warnings point out things
+// which are likely mistakes but don't prevent the plan from running.
+type Problem struct {
+	Line    int
+	Column  int
+	Warning bool
+	Msg     string
+}
+
+func (p Problem) String() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	if p.Column == 0 {
+		return fmt.Sprintf("%d: %s: %s", p.Line, level, p.Msg)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", p.Line, p.Column, level, p.Msg)
+}
+
+// ValidatePlan checks the YAML plan read from the given io.Reader without
+// running it, returning every Problem found in order of appearance. Besides
+// the plan being well formed, it checks the requests of every step and that
+// the variables they use are set by earlier steps of their plan. The error
+// is only non nil if the plan can't be read.
+func ValidatePlan(r io.Reader) ([]Problem, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading plan: %w", err)
+	}
+
+	var (
+		v   validator
+		doc yaml.Node
+	)
+
+	if err = yaml.Unmarshal(src, &doc); err != nil {
+		v.problems = append(v.problems, lineProblem(&doc, strings.TrimPrefix(err.Error(), "yaml: ")))
+		return v.problems, nil
+	}
+
+	if len(doc.Content) == 0 {
+		v.problems = append(v.problems, Problem{Line: 1, Column: 1, Msg: "plan is empty"})
+		return v.problems, nil
+	}
+
+	v.plan(doc.Content[0])
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		if v.problems[i].Line != v.problems[j].Line {
+			return v.problems[i].Line < v.problems[j].Line
+		}
+		return v.problems[i].Column < v.problems[j].Column
+	})
+
+	return v.problems, nil
+}
+
+type validator struct {
+	problems []Problem
+	// legacy is set when the plan's apiVersion is upgraded by ReadConfig.
+	legacy func(*Config) []string
+}
+
+func (v *validator) errorf(n *yaml.Node, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(n *yaml.Node, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Line: n.Line, Column: n.Column, Warning: true, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) plan(root *yaml.Node) {
+	if root.Kind != yaml.MappingNode {
+		v.errorf(root, "plan must be a mapping")
+		return
+	}
+
+	switch version := field(root, "apiVersion"); {
+	case version == nil:
+		v.errorf(root, "missing apiVersion, use %s", APIVersion)
+	case version.Value == APIVersion:
+	case upgraders[version.Value] != nil:
+		v.legacy = upgraders[version.Value]
+		v.warnf(version, "apiVersion %s is deprecated, use %s", version.Value, APIVersion)
+	default:
+		v.errorf(version, "%s %q, use %s", ErrAPIVersion, version.Value, APIVersion)
+	}
+
+	if cfg := field(root, "config"); cfg != nil {
+		v.decode(cfg, &TestConfig{})
+	}
+
+	plans := field(root, "targetPlan")
+	switch {
+	case plans == nil:
+		v.errorf(root, "missing targetPlan")
+		return
+	case plans.Kind != yaml.SequenceNode:
+		v.errorf(plans, "targetPlan must be a list of plans")
+		return
+	case len(plans.Content) == 0:
+		v.errorf(plans, "targetPlan has no plans")
+		return
+	}
+
+	names := map[string]bool{}
+	for _, n := range plans.Content {
+		name := v.targetPlan(n)
+		if names[name] {
+			v.warnf(n, "plan %q is defined more than once, their results can't be told apart", name)
+		}
+		names[name] = true
+	}
+}
+
+// targetPlan validates a plan of the targetPlan list, returning its name.
+func (v *validator) targetPlan(n *yaml.Node) string {
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "plan must be a mapping")
+		return ""
+	}
+
+	// The targets are decoded on their own, so the problems of every step
+	// are found rather than only the first one.
+	var (
+		plan    Plan
+		targets *yaml.Node
+		rest    = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: n.Line, Column: n.Column}
+	)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == "targets" {
+			targets = n.Content[i+1]
+			continue
+		}
+		rest.Content = append(rest.Content, n.Content[i], n.Content[i+1])
+	}
+	v.decode(rest, &plan)
+
+	if plan.Weight < 0 {
+		v.errorf(field(n, "weight"), "negative weight %d", plan.Weight)
+	}
+
+	switch {
+	case targets == nil || targets.Kind == yaml.ScalarNode && targets.Tag == "!!null":
+		v.errorf(n, "plan %q has no targets", plan.Name)
+		return plan.Name
+	case targets.Kind != yaml.SequenceNode:
+		v.errorf(targets, "targets must be a list of steps")
+		return plan.Name
+	case len(targets.Content) == 0:
+		v.errorf(targets, "plan %q has no targets", plan.Name)
+		return plan.Name
+	}
+
+	// set holds the variables set by the steps checked so far, starting
+	// with the fields of the plan's data.
+	set := map[string]bool{}
+	if plan.Data != nil {
+		feeder, err := plan.Data.Feeder()
+		if err != nil {
+			v.errorf(field(n, "data"), "%v", err)
+		} else {
+			for _, col := range feeder.Columns() {
+				set[col] = true
+			}
+		}
+	}
+	for _, step := range targets.Content {
+		// What can be decoded of a malformed step is still checked.
+		var setup TargetSetup
+		v.decode(step, &setup)
+
+		if v.legacy != nil {
+			cfg := Config{TargetPlan: []Plan{{Targets: []TargetSetup{setup}}}}
+			v.legacy(&cfg)
+			setup = cfg.TargetPlan[0].Targets[0]
+		}
+
+		set = v.step(step, &setup, set)
+	}
+
+	return plan.Name
+}
+
+// step validates a step of a plan given the variables set by the steps
+// before it, returning the variables set once it's done.
+func (v *validator) step(n *yaml.Node, setup *TargetSetup, set map[string]bool) map[string]bool {
+	run := field(n, "run")
+	if run == nil {
+		v.errorf(n, "target has no run")
+		return set
+	}
+
+	switch method := field(run, "method"); {
+	case method == nil || setup.Run.Method == "":
+		v.errorf(run, "%v", ErrNoMethod)
+	case !slices.Contains(httpMethods, strings.ToUpper(setup.Run.Method)):
+		v.warnf(method, "unknown method %q", setup.Run.Method)
+	case strings.ToUpper(setup.Run.Method) != setup.Run.Method:
+		v.warnf(method, "method %q isn't upper case", setup.Run.Method)
+	}
+
+	urlNode := field(run, "url")
+	if urlNode == nil || setup.Run.Url == "" {
+		v.errorf(run, "%v", ErrNoURL)
+	} else {
+		v.url(urlNode, setup.Run.Url)
+		if holes := strings.Count(setup.Run.Url, "%s"); holes != len(setup.Run.Params) {
+			v.errorf(urlNode, "url has %d %%s placeholders but %d params", holes, len(setup.Run.Params))
+		}
+	}
+
+	// The variables of cache-get are bound before the request is built.
+	local := map[string]bool{}
+	for k := range set {
+		local[k] = true
+	}
+	if get := field(field(n, "preRun"), "cache-get"); get != nil {
+		for _, entry := range get.Content {
+			for i := 0; i+1 < len(entry.Content); i += 2 {
+				if key := entry.Content[i+1]; !set[key.Value] {
+					v.errorf(key, "cache-get %q: no earlier step of the plan sets it", key.Value)
+				}
+				local[entry.Content[i].Value] = true
+			}
+		}
+	}
+
+	if urlNode != nil {
+		v.template(urlNode)
+		v.refs(urlNode, setup.Run.Url, local)
+	}
+	if params := field(run, "params"); params != nil {
+		for _, p := range params.Content {
+			name, ok := paramVar(p.Value)
+			switch {
+			case !ok:
+				v.refs(p, p.Value, local)
+			case !local[name]:
+				v.errorf(p, "param %q: %v", name, ErrUnsetVar)
+			}
+		}
+	}
+	v.walk(field(run, "query"), local)
+	v.walk(field(run, "headers"), local)
+	v.walk(field(run, "body"), local)
+	if body := setup.Run.Body; body != nil {
+		v.files(field(run, "body"), body)
+	}
+
+	if cacheSet := field(field(n, "postRun"), "cache-set"); cacheSet != nil {
+		for i, entry := range cacheSet.Content {
+			if i >= len(setup.PostRun.CacheSet) {
+				break
+			}
+			cs := setup.PostRun.CacheSet[i]
+			x := Extractor{Var: cs.CacheKey, JSONPath: cs.ResponseKey, Header: cs.Header, Regex: cs.Regex}
+			if err := x.compile(); err != nil {
+				v.errorf(entry, "cache-set: %v", err)
+			}
+			local[cs.CacheKey] = true
+		}
+	}
+
+	if checks := field(n, "checks"); checks != nil {
+		for i, entry := range checks.Content {
+			if i >= len(setup.Checks) {
+				break
+			}
+			c := setup.Checks[i]
+			if err := c.compile(); err != nil {
+				v.errorf(entry, "check: %v", err)
+			}
+		}
+	}
+
+	return local
+}
+
+// url checks a step's URL is absolute once its templates, variables and
+// placeholders are filled in.
+func (v *validator) url(n *yaml.Node, raw string) {
+	filled := strings.ReplaceAll(raw, "%s", "x")
+	for _, delims := range [][2]string{{"{{", "}}"}, {"${", "}"}} {
+		for {
+			start := strings.Index(filled, delims[0])
+			if start == -1 {
+				break
+			}
+			end := strings.Index(filled[start:], delims[1])
+			if end == -1 {
+				break
+			}
+			filled = filled[:start] + "x" + filled[start+end+len(delims[1]):]
+		}
+	}
+
+	u, err := url.Parse(filled)
+	switch {
+	case err != nil:
+		v.errorf(n, "invalid url: %v", err)
+	case strings.HasPrefix(raw, "${") || strings.HasPrefix(raw, "{{"):
+		// The scheme and host come from a variable or a template.
+	case u.Scheme != "http" && u.Scheme != "https":
+		v.errorf(n, "url %q must start with http:// or https://", raw)
+	case u.Host == "":
+		v.errorf(n, "url %q has no host", raw)
+	}
+}
+
+// files checks the files sent by a body exist, unless their paths depend
+// on variables.
+func (v *validator) files(n *yaml.Node, body *RequestBody) {
+	check := func(at *yaml.Node, path string) {
+		if strings.Contains(path, "${") {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			v.errorf(at, "body: %v", err)
+		}
+	}
+
+	if body.File != "" {
+		check(field(n, "file"), body.File)
+	}
+	if body.Multipart != nil {
+		files := field(field(n, "multipart"), "files")
+		for _, k := range sortedKeys(body.Multipart.Files) {
+			check(field(files, k), body.Multipart.Files[k])
+		}
+	}
+}
+
+// walk checks the variables referenced by every scalar under the node.
+func (v *validator) walk(n *yaml.Node, set map[string]bool) {
+	if n == nil {
+		return
+	}
+	if n.Kind == yaml.ScalarNode {
+		v.template(n)
+		v.refs(n, n.Value, set)
+	}
+	for _, c := range n.Content {
+		v.walk(c, set)
+	}
+}
+
+// template checks the request template of a scalar parses.
+func (v *validator) template(n *yaml.Node) {
+	if _, err := parseTemplate("", n.Value); err != nil {
+		v.errorf(n, "%v", err)
+	}
+}
+
+// refs checks every ${name} reference of s names a variable in set.
+func (v *validator) refs(n *yaml.Node, s string, set map[string]bool) {
+	for rest := s; ; {
+		start := strings.Index(rest, "${")
+		if start == -1 {
+			return
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end == -1 {
+			v.errorf(n, "unterminated variable reference in %q", s)
+			return
+		}
+		if name := rest[start+2 : start+end]; !set[name] {
+			v.errorf(n, "%q: %v by an earlier step of the plan", name, ErrUnsetVar)
+		}
+		rest = rest[start+end+1:]
+	}
+}
+
+// decode decodes the node into out, reporting whatever goes wrong.
+func (v *validator) decode(n *yaml.Node, out any) {
+	err := n.Decode(out)
+	if err == nil {
+		return
+	}
+
+	var te *yaml.TypeError
+	if errors.As(err, &te) {
+		for _, msg := range te.Errors {
+			v.problems = append(v.problems, lineProblem(n, msg))
+		}
+		return
+	}
+
+	// Errors of custom unmarshalers carry no position and abort decoding,
+	// so report them at every member which fails to decode on its own and
+	// decode the others. Type errors don't abort decoding, so members with
+	// nothing else wrong are kept and their errors reported by decoding the
+	// rest.
+	if n.Kind != yaml.MappingNode {
+		v.errorf(n, "%v", err)
+		return
+	}
+
+	rest := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: n.Line, Column: n.Column}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		member := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: n.Content[i : i+2]}
+		if err := member.Decode(reflect.New(reflect.TypeOf(out).Elem()).Interface()); err != nil && !errors.As(err, &te) {
+			v.errorf(n.Content[i+1], "%v", err)
+			continue
+		}
+		rest.Content = append(rest.Content, n.Content[i], n.Content[i+1])
+	}
+	v.decode(rest, out)
+}
+
+// lineProblem turns a YAML error message starting with "line N: " into a
+// Problem at that line, or at the given node if it has no such prefix.
+func lineProblem(n *yaml.Node, msg string) Problem {
+	p := Problem{Line: n.Line, Column: n.Column, Msg: msg}
+	if rest, ok := strings.CutPrefix(msg, "line "); ok {
+		if num, text, ok := strings.Cut(rest, ": "); ok {
+			if line, err := strconv.Atoi(num); err == nil {
+				p = Problem{Line: line, Msg: text}
+			}
+		}
+	}
+	return p
+}
+
+// field returns the value node of the given key of a mapping node, or nil.
+func field(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
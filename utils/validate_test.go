@@ -0,0 +1,43 @@
+package gogeta
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePlanNestedTypeError(t *testing.T) {
+	t.Parallel()
+
+	// The think time fails to decode on its own, so the step is decoded
+	// member by member, and the type error of the run's header is reported
+	// too.
+	const plan = `apiVersion: v1
+targetPlan:
+  - name: p
+    targets:
+      - think: bogus
+        run:
+          method: GET
+          url: http://localhost/
+          headers:
+            X-A: [1, 2]
+`
+
+	problems, err := ValidatePlan(strings.NewReader(plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Problem{
+		{Line: 5, Column: 16, Msg: `think: time: invalid duration "bogus"`},
+		{Line: 10, Msg: "cannot unmarshal !!seq into string"},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got problems %v, want %v", problems, want)
+	}
+	for i := range want {
+		if problems[i] != want[i] {
+			t.Errorf("problem %d: got %v, want %v", i, problems[i], want[i])
+		}
+	}
+}